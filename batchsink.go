@@ -0,0 +1,300 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy describes how BatchSink handles errors logged when its queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the logging goroutine until there is space in the queue. It is the default policy.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the logged error, keeping the queued ones
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued error to make space for the logged one
+	OverflowDropOldest
+)
+
+// ErrSinkClosed is returned when the error is written to the closed sink
+var ErrSinkClosed = fmt.Errorf("sink is closed")
+
+type batchSinkOpts struct {
+	queueSize      int
+	batchSize      int
+	flushInterval  time.Duration
+	overflowPolicy OverflowPolicy
+}
+
+type BatchSinkOption func(*batchSinkOpts)
+
+// WithQueueSize sets the maximum number of queued errors. The default is 1024.
+func WithQueueSize(size int) BatchSinkOption {
+	return func(o *batchSinkOpts) {
+		o.queueSize = size
+	}
+}
+
+// WithBatchSize sets the number of queued errors starting the write before the flush interval. The default is 64.
+func WithBatchSize(size int) BatchSinkOption {
+	return func(o *batchSinkOpts) {
+		o.batchSize = size
+	}
+}
+
+// WithFlushInterval sets the interval of writing queued errors. The default is 1 second. Zero or negative interval
+// disables periodic writes, queued errors are written when the batch is full, on Flush and on Close.
+func WithFlushInterval(interval time.Duration) BatchSinkOption {
+	return func(o *batchSinkOpts) {
+		o.flushInterval = interval
+	}
+}
+
+// WithOverflowPolicy sets how errors logged when the queue is full are handled
+func WithOverflowPolicy(policy OverflowPolicy) BatchSinkOption {
+	return func(o *batchSinkOpts) {
+		o.overflowPolicy = policy
+	}
+}
+
+// BatchWriter is implemented by sinks writing multiple errors at once, like WebhookSink. BatchSink passes whole
+// batches to such sinks instead of writing errors one by one. Records are valid only during the call.
+type BatchWriter interface {
+	WriteBatch(errs []EnhancedError, records [][]byte) error
+}
+
+// batchRecord is the queued error with the copy of its record
+type batchRecord struct {
+	e      EnhancedError
+	record []byte
+}
+
+// BatchSink queues errors and writes them to the wrapped sink on a separate goroutine, in batches of the given size
+// or every flush interval. Failures of the wrapped sink are reported with ReportSinkError. The sink should be
+// registered with RegisterFlusher, so queued errors are written by Flush and Close of the manager.
+type BatchSink struct {
+	dropped int64
+
+	sink Sink
+	opts *batchSinkOpts
+
+	mu      sync.Mutex
+	notFull *sync.Cond
+	queue   []batchRecord
+	writing bool
+	// idle are closed when the queue is written
+	idle   []chan struct{}
+	closed bool
+
+	wake    chan struct{}
+	stopped chan struct{}
+}
+
+// NewBatchSink returns the sink writing errors to sink asynchronously
+func NewBatchSink(sink Sink, opts ...BatchSinkOption) *BatchSink {
+	options := &batchSinkOpts{
+		queueSize:     1024,
+		batchSize:     64,
+		flushInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.queueSize < 1 {
+		options.queueSize = 1
+	}
+	if options.batchSize < 1 || options.batchSize > options.queueSize {
+		options.batchSize = options.queueSize
+	}
+	s := &BatchSink{
+		sink:    sink,
+		opts:    options,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	s.notFull = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *BatchSink) WriteError(e EnhancedError, record []byte) error {
+	s.mu.Lock()
+	for s.opts.overflowPolicy == OverflowBlock && len(s.queue) >= s.opts.queueSize && !s.closed {
+		s.notFull.Wait()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSinkClosed
+	}
+	if len(s.queue) >= s.opts.queueSize {
+		atomic.AddInt64(&s.dropped, 1)
+		if s.opts.overflowPolicy == OverflowDropNewest {
+			s.mu.Unlock()
+			return nil
+		}
+		s.queue[0] = batchRecord{}
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, batchRecord{e: e, record: append([]byte(nil), record...)})
+	full := len(s.queue) >= s.opts.batchSize
+	s.mu.Unlock()
+	if full {
+		s.notify()
+	}
+	return nil
+}
+
+// Dropped returns the number of errors dropped because the queue was full
+func (s *BatchSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Queued returns the number of errors waiting to be written
+func (s *BatchSink) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Flush writes queued errors and flushes the wrapped sink when it implements Flusher
+func (s *BatchSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	if len(s.queue) > 0 || s.writing {
+		idle := make(chan struct{})
+		s.idle = append(s.idle, idle)
+		s.mu.Unlock()
+		s.notify()
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		s.mu.Unlock()
+	}
+	if flusher, ok := s.sink.(Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
+// Close writes queued errors, stops the sink and closes the wrapped sink when it implements io.Closer.
+// Errors written after Close are rejected with ErrSinkClosed.
+func (s *BatchSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.notFull.Broadcast()
+	s.mu.Unlock()
+	s.notify()
+	<-s.stopped
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *BatchSink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *BatchSink) run() {
+	defer close(s.stopped)
+	var tick <-chan time.Time
+	if s.opts.flushInterval > 0 {
+		ticker := time.NewTicker(s.opts.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.wake:
+		case <-tick:
+		}
+		if closed := s.writeQueued(); closed {
+			return
+		}
+	}
+}
+
+// writeQueued writes batches until the queue is empty and returns whether the sink is closed
+func (s *BatchSink) writeQueued() bool {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.queue = nil
+			s.writing = false
+			for _, idle := range s.idle {
+				close(idle)
+			}
+			s.idle = nil
+			closed := s.closed
+			s.mu.Unlock()
+			return closed
+		}
+		size := s.opts.batchSize
+		if size > len(s.queue) {
+			size = len(s.queue)
+		}
+		batch := s.queue[:size:size]
+		s.queue = s.queue[size:]
+		s.writing = true
+		s.notFull.Broadcast()
+		s.mu.Unlock()
+		if writer, ok := s.sink.(BatchWriter); ok {
+			s.writeBatch(writer, batch)
+			continue
+		}
+		for _, r := range batch {
+			s.write(r)
+		}
+	}
+}
+
+// writeBatch writes the batch with a single call, reporting the failure for every error of the batch
+func (s *BatchSink) writeBatch(writer BatchWriter, batch []batchRecord) {
+	errs := make([]EnhancedError, len(batch))
+	records := make([][]byte, len(batch))
+	for i, r := range batch {
+		errs[i], records[i] = r.e, r.record
+	}
+	var sinkErr error
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				sinkErr = fmt.Errorf("sink panicked: %v", p)
+			}
+		}()
+		if err := writer.WriteBatch(errs, records); err != nil {
+			sinkErr = fmt.Errorf("writing error: %w", err)
+		}
+	}()
+	if sinkErr == nil {
+		return
+	}
+	for _, e := range errs {
+		ReportSinkError(e, sinkErr)
+	}
+}
+
+func (s *BatchSink) write(r batchRecord) {
+	defer func() {
+		if p := recover(); p != nil {
+			ReportSinkError(r.e, fmt.Errorf("sink panicked: %v", p))
+		}
+	}()
+	if err := s.sink.WriteError(r.e, r.record); err != nil {
+		ReportSinkError(r.e, fmt.Errorf("writing error: %w", err))
+	}
+}
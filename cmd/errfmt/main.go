@@ -0,0 +1,231 @@
+// Command errfmt pretty prints errors logged with LogFMTFormatter. It reads logs from stdin and writes them to stdout
+// in the MultilineFormatter style. Lines which are not error records are copied unchanged.
+//
+// Usage:
+//
+//	kubectl logs pod | errfmt -stacks stacks.txt
+//
+// When the stack trace was not logged, it is looked up by errorCode in the stack store given with -stacks.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/internal/record"
+	"github.com/logrusorgru/aurora"
+)
+
+func main() {
+	stacksPath := flag.String("stacks", "", "path to the stack file or directory used to expand errorCode")
+	noColor := flag.Bool("no-color", false, "disable colors")
+	flag.Parse()
+
+	f := &formatter{colors: aurora.NewAurora(!*noColor), reloadInterval: stackReloadInterval}
+	if *stacksPath != "" {
+		format, err := errors.DetectStackFormat(*stacksPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "errfmt: %s\n", err)
+			os.Exit(1)
+		}
+		store, err := errors.OpenStackStoreReadOnly(*stacksPath, format)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "errfmt: %s\n", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		f.stacks = store
+	}
+	if err := f.run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "errfmt: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// stackReloadInterval limits how often the stack store is read again for error codes missing in it
+const stackReloadInterval = 5 * time.Second
+
+type formatter struct {
+	colors aurora.Aurora
+	stacks errors.StackStore
+	// reloadInterval is the minimal time between reads of the stack store
+	reloadInterval time.Duration
+	// traces holds stack traces of the store by error code, it is loaded on the first lookup
+	traces   map[string]string
+	loadedAt time.Time
+}
+
+// stackTrace returns the formatted stack trace saved for the error code. The store is read on the first lookup and
+// read again for codes missing in it at most once per reload interval, as stack traces may be saved later by
+// the process the logs are streamed from.
+func (f *formatter) stackTrace(errorCode string) string {
+	if trace, ok := f.traces[errorCode]; ok {
+		return trace
+	}
+	if f.traces != nil && time.Since(f.loadedAt) < f.reloadInterval {
+		return ""
+	}
+	f.loadedAt = time.Now()
+	traces := make(map[string]string)
+	err := f.stacks.Each(func(entry errors.StackEntry) error {
+		traces[entry.Hash] = entry.Format()
+		return nil
+	})
+	if err == nil || f.traces == nil {
+		f.traces = traces
+	}
+	return f.traces[errorCode]
+}
+
+func (f *formatter) run(in io.Reader, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		rec, ok := record.ParseLogFMT(line)
+		if !ok {
+			fmt.Fprintln(w, line)
+			continue
+		}
+		w.WriteString(f.format(rec))
+	}
+	return scanner.Err()
+}
+
+// layer is a single error layer of the record. Fields of cause layers are prefixed with "cause." for every level.
+type layer struct {
+	errorID string
+	fields  []record.Field
+}
+
+// layerFields are rendered in the layer header instead of the options
+var layerFields = map[string]bool{
+	"errorID":      true,
+	"template":     true,
+	"templatePath": true,
+	"content":      true,
+	"parentIDs":    true,
+	"wraps":        true,
+}
+
+func splitLayers(rec record.Record) []*layer {
+	layers := []*layer{{}}
+	for _, field := range rec.Fields {
+		depth := 0
+		for strings.HasPrefix(field.Key, "cause.") {
+			field.Key = strings.TrimPrefix(field.Key, "cause.")
+			depth++
+		}
+		if depth == 0 && (field.Key == "errorCode" || field.Key == "level" || field.Key == "stackTrace") {
+			continue
+		}
+		for len(layers) <= depth {
+			layers = append(layers, &layer{})
+		}
+		if field.Key == "errorID" {
+			layers[depth].errorID = field.Value
+		}
+		layers[depth].fields = append(layers[depth].fields, field)
+	}
+	return layers
+}
+
+func (f *formatter) format(rec record.Record) string {
+	var sb strings.Builder
+	errorCode, _ := rec.Get("errorCode")
+	errorID, _ := rec.Get("errorID")
+	sb.WriteString(fmt.Sprintf("%s--- %s --- %s --- %s \n", rec.Prefix, f.colors.Red("ERROR"), f.colors.Blue(errorCode), errorID))
+	if level, ok := rec.Get("level"); ok {
+		sb.WriteString(fmt.Sprintf("\tLEVEL: %s \n", level))
+	}
+	for i, l := range splitLayers(rec) {
+		indent := "\t"
+		if i > 0 {
+			sb.WriteString(fmt.Sprintf("\tCAUSED BY: %s \n", l.errorID))
+			indent = "\t\t"
+		}
+		f.writeLayer(&sb, l, indent)
+	}
+	stackTrace, ok := rec.Get("stackTrace")
+	if ok {
+		stackTrace = strings.ReplaceAll(stackTrace, "$", "\n")
+	} else if f.stacks != nil && errorCode != "" {
+		stackTrace = f.stackTrace(errorCode)
+	}
+	if stackTrace != "" {
+		sb.WriteString(fmt.Sprintf("\tSTACK TRACE: \n%s", stackTrace))
+		if !strings.HasSuffix(stackTrace, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func (f *formatter) writeLayer(sb *strings.Builder, l *layer, indent string) {
+	values := make(map[string]string)
+	opts := make(map[string]json.RawMessage)
+	var optKeys []string
+	for _, field := range l.fields {
+		if layerFields[field.Key] {
+			values[field.Key] = field.Value
+			continue
+		}
+		opts[field.Key] = jsonValue(field.Value)
+		optKeys = append(optKeys, field.Key)
+	}
+	if templatePath, ok := values["templatePath"]; ok {
+		sb.WriteString(fmt.Sprintf("%sTEMPLATE: %s \n", indent, strings.ReplaceAll(templatePath, "/", " > ")))
+	} else if template, ok := values["template"]; ok {
+		sb.WriteString(fmt.Sprintf("%sTEMPLATE: %s \n", indent, template))
+	}
+	if parentIDs, ok := values["parentIDs"]; ok {
+		sb.WriteString(fmt.Sprintf("%sPARENT IDS: %s \n", indent, strings.ReplaceAll(parentIDs, ",", ", ")))
+	}
+	sb.WriteString(fmt.Sprintf("%sCONTENT: %s \n", indent, values["content"]))
+	var wraps []struct {
+		Message  string          `json:"message"`
+		Location string          `json:"location"`
+		Opts     json.RawMessage `json:"opts"`
+	}
+	if err := json.Unmarshal([]byte(values["wraps"]), &wraps); err == nil && len(wraps) > 0 {
+		sb.WriteString(fmt.Sprintf("%sWRAPS: \n", indent))
+		for _, frame := range wraps {
+			sb.WriteString(fmt.Sprintf("%s\t%s at %s", indent, frame.Message, frame.Location))
+			if len(frame.Opts) > 0 {
+				sb.WriteString(" ")
+				sb.Write(frame.Opts)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if len(optKeys) > 0 {
+		optBytes, err := json.MarshalIndent(opts, indent, "  ")
+		if err == nil {
+			sb.WriteString(indent)
+			sb.Write(optBytes)
+			sb.WriteString("\n")
+		}
+	}
+}
+
+// jsonValue returns the value as JSON. Values logged as JSON, like numbers or structures, are kept as they are.
+func jsonValue(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(value)); err == nil {
+			return compact.Bytes()
+		}
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
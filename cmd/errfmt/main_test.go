@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/opts"
+	"github.com/logrusorgru/aurora"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatLogFMT(t *testing.T) {
+	inner := errors.Template().From(fmt.Errorf("no rows")).With(opts.Debug("query"))
+	err := errors.Template().From(inner).With(opts.StatusCode(404), opts.Title("not found")).Wrap("handling")
+	withStack := errors.LogFMTFormatter(err, 100, errors.MultilineStackTraceFormatter)
+	withoutStack := errors.LogFMTFormatter(err, 100, errors.NoStackTrace)
+
+	store := errors.NewMemoryStackStore()
+	assert.NoError(t, store.Put(errors.StackEntry{
+		Hash:  err.GetStackTraceHash(),
+		Trace: errors.MultilineStackTraceFormatter(err.GetStackTrace()),
+	}))
+	f := &formatter{colors: aurora.NewAurora(false), stacks: store}
+	input := "2023/01/02 10:00:00 " + withStack + "plain line\n2023/01/02 10:00:01 " + withoutStack
+	var out bytes.Buffer
+	assert.NoError(t, f.run(strings.NewReader(input), &out))
+
+	records := strings.Split(out.String(), "plain line\n")
+	assert.Len(t, records, 2)
+	for _, rec := range records {
+		assert.Contains(t, rec, fmt.Sprintf("--- ERROR --- %s --- %s \n", err.GetStackTraceHash(), err.GetErrorID()))
+		assert.Contains(t, rec, "\tLEVEL: info \n")
+		assert.Contains(t, rec, "\tCONTENT: handling: no rows \n")
+		assert.Contains(t, rec, "\t  \"statusCode\": 404")
+		assert.Contains(t, rec, "\tCAUSED BY: "+inner.GetErrorID()+" \n")
+		assert.Contains(t, rec, "\t\tCONTENT: no rows \n")
+		assert.Contains(t, rec, "\tSTACK TRACE: \n"+errors.MultilineStackTraceFormatter(err.GetStackTrace()))
+	}
+	assert.True(t, strings.HasPrefix(records[1], "2023/01/02 10:00:01 --- ERROR"))
+}
+
+// countingStore counts iterations over the stack store
+type countingStore struct {
+	errors.StackStore
+	reads int
+}
+
+func (s *countingStore) Each(fn func(errors.StackEntry) error) error {
+	s.reads++
+	return s.StackStore.Each(fn)
+}
+
+func TestFormatThrottlesStoreReads(t *testing.T) {
+	tmpl := errors.Template()
+	err := tmpl.From(fmt.Errorf("no rows"))
+	later := tmpl.From(fmt.Errorf("saved later"))
+	line := errors.LogFMTFormatter(err, 100, errors.NoStackTrace)
+	laterLine := errors.LogFMTFormatter(later, 100, errors.NoStackTrace)
+
+	store := &countingStore{StackStore: errors.NewMemoryStackStore()}
+	assert.NoError(t, store.Put(errors.StackEntry{
+		Hash:  err.GetStackTraceHash(),
+		Trace: errors.MultilineStackTraceFormatter(err.GetStackTrace()),
+	}))
+	f := &formatter{colors: aurora.NewAurora(false), stacks: store, reloadInterval: time.Hour}
+	var out bytes.Buffer
+	assert.NoError(t, f.run(strings.NewReader(line+line+laterLine+laterLine+line), &out))
+	assert.Equal(t, 3, strings.Count(out.String(), "STACK TRACE"))
+	// missing codes do not read the store again within the reload interval
+	assert.Equal(t, 1, store.reads)
+
+	// the stack trace saved later by the tailed process is found after the reload interval
+	assert.NoError(t, store.Put(errors.StackEntry{
+		Hash:  later.GetStackTraceHash(),
+		Trace: errors.MultilineStackTraceFormatter(later.GetStackTrace()),
+	}))
+	f.reloadInterval = 0
+	out.Reset()
+	assert.NoError(t, f.run(strings.NewReader(laterLine+laterLine), &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "STACK TRACE"))
+	assert.Equal(t, 2, store.reads)
+}
@@ -0,0 +1,241 @@
+// Command errquery aggregates errors logged with LogFMTFormatter or AsJSON. It reads logs from stdin, groups errors
+// by the given keys and prints the number of errors, the first and the last time they were logged and a sample errorID.
+//
+// Usage:
+//
+//	errquery [-by errorCode] [-json] [filter...] < app.log
+//	errquery -by template,statusCode 'statusCode>=500' error=outsideService < app.log
+//
+// Filters compare the field with the value using one of =, !=, >, >=, < or <=. Values are compared as numbers
+// when both of them are numbers. Errors missing the field pass only the != filters.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/enhanced-tools/errors/internal/record"
+)
+
+const usage = `usage: errquery [-by keys] [-json] [filter...] < logs
+
+filters:
+  key=value, key!=value, key>value, key>=value, key<value, key<=value
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "errquery: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("errquery", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	by := flags.String("by", "errorCode", "comma separated keys to group errors by, for example errorCode, template, error or any option key")
+	asJSON := flags.Bool("json", false, "print groups as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filters := make([]filter, 0, flags.NArg())
+	for _, arg := range flags.Args() {
+		f, err := parseFilter(arg)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, f)
+	}
+	keys := strings.Split(*by, ",")
+	groups, err := aggregate(in, keys, filters)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(out, groups)
+	}
+	return printTable(out, keys, groups)
+}
+
+// group is a set of errors with the same values of the grouping keys
+type group struct {
+	Keys        []string
+	Values      []string
+	Count       int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	SampleError string
+}
+
+func (g *group) MarshalJSON() ([]byte, error) {
+	values := make(map[string]string, len(g.Keys))
+	for i, key := range g.Keys {
+		values[key] = g.Values[i]
+	}
+	output := map[string]interface{}{
+		"group":         values,
+		"count":         g.Count,
+		"sampleErrorID": g.SampleError,
+	}
+	if !g.FirstSeen.IsZero() {
+		output["firstSeen"] = g.FirstSeen
+		output["lastSeen"] = g.LastSeen
+	}
+	return json.Marshal(output)
+}
+
+func (g *group) add(rec record.Record) {
+	g.Count++
+	if g.SampleError == "" {
+		g.SampleError, _ = rec.Get("errorID")
+	}
+	t, ok := rec.Time()
+	if !ok {
+		return
+	}
+	if g.FirstSeen.IsZero() || t.Before(g.FirstSeen) {
+		g.FirstSeen = t
+	}
+	if t.After(g.LastSeen) {
+		g.LastSeen = t
+	}
+}
+
+// aggregate groups errors read from logs, sorting groups by the number of errors
+func aggregate(in io.Reader, keys []string, filters []filter) ([]*group, error) {
+	groups := make(map[string]*group)
+	var order []*group
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		rec, ok := record.Parse(scanner.Text())
+		if !ok || !matches(rec, filters) {
+			continue
+		}
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i], _ = rec.Get(key)
+		}
+		id := strings.Join(values, "\x00")
+		g, ok := groups[id]
+		if !ok {
+			g = &group{Keys: keys, Values: values}
+			groups[id] = g
+			order = append(order, g)
+		}
+		g.add(rec)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Count > order[j].Count
+	})
+	return order, scanner.Err()
+}
+
+// filter compares the field of the record with the value
+type filter struct {
+	key   string
+	op    string
+	value string
+}
+
+// filterOps are checked in order, so two character operators are matched before their prefixes
+var filterOps = []string{">=", "<=", "!=", "=", ">", "<"}
+
+func parseFilter(arg string) (filter, error) {
+	for i := range arg {
+		for _, op := range filterOps {
+			if strings.HasPrefix(arg[i:], op) && i > 0 {
+				return filter{key: arg[:i], op: op, value: arg[i+len(op):]}, nil
+			}
+		}
+	}
+	return filter{}, fmt.Errorf("invalid filter %s", arg)
+}
+
+func (f filter) match(rec record.Record) bool {
+	value, ok := rec.Get(f.key)
+	if !ok {
+		return f.op == "!="
+	}
+	cmp := strings.Compare(value, f.value)
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(f.value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch f.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp <= 0
+}
+
+func matches(rec record.Record, filters []filter) bool {
+	for _, f := range filters {
+		if !f.match(rec) {
+			return false
+		}
+	}
+	return true
+}
+
+func printTable(out io.Writer, keys []string, groups []*group) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t", strings.ToUpper(key))
+	}
+	fmt.Fprintln(w, "COUNT\tFIRST SEEN\tLAST SEEN\tSAMPLE ERROR ID")
+	for _, g := range groups {
+		for _, value := range g.Values {
+			if value == "" {
+				value = "-"
+			}
+			fmt.Fprintf(w, "%s\t", value)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", g.Count, formatTime(g.FirstSeen), formatTime(g.LastSeen), g.SampleError)
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func printJSON(out io.Writer, groups []*group) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if groups == nil {
+		groups = []*group{}
+	}
+	return encoder.Encode(groups)
+}
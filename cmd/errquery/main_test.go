@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/opts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	notFound := errors.Template().With(opts.StatusCode(404), opts.ErrNameResources)
+	outside := errors.Template().With(opts.StatusCode(502), opts.ErrNameOutsideService)
+	var logs strings.Builder
+	for i, e := range []errors.EnhancedError{
+		notFound.FromEmpty(),
+		outside.FromEmpty(),
+		outside.FromEmpty(),
+		notFound.FromEmpty(),
+		outside.FromEmpty(),
+	} {
+		timestamp := fmt.Sprintf("2023/01/02 10:00:0%d ", i)
+		if i%2 == 0 {
+			logs.WriteString(timestamp + errors.LogFMTFormatter(e, 100, errors.NoStackTrace))
+		} else {
+			logs.WriteString(timestamp + string(errors.AsJSON(e)) + "\n")
+		}
+		logs.WriteString("not an error\n")
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"-by", "error,statusCode", "-json", "statusCode>=500"}, strings.NewReader(logs.String()), &out))
+	var groups []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &groups))
+	assert.Len(t, groups, 1)
+	assert.Equal(t, map[string]interface{}{"error": "outsideService", "statusCode": "502"}, groups[0]["group"])
+	assert.Equal(t, 3.0, groups[0]["count"])
+	assert.Equal(t, "2023-01-02T10:00:01", groups[0]["firstSeen"].(string)[:19])
+	assert.Equal(t, "2023-01-02T10:00:04", groups[0]["lastSeen"].(string)[:19])
+
+	out.Reset()
+	assert.NoError(t, run([]string{"-by", "error", "error!=outsideService"}, strings.NewReader(logs.String()), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"ERROR", "COUNT", "FIRST", "SEEN", "LAST", "SEEN", "SAMPLE", "ERROR", "ID"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"resource", "2"}, strings.Fields(lines[1])[:2])
+
+	assert.Error(t, run([]string{"statusCode"}, strings.NewReader(""), &out))
+}
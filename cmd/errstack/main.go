@@ -0,0 +1,316 @@
+// Command errstack looks up stack traces saved by the errors manager by their hash (the errorCode field in logs).
+//
+// Usage:
+//
+//	errstack [-stacks stacks.txt] show <hash>
+//	errstack [-stacks stacks.txt] list
+//	errstack [-stacks stacks.txt] grep <func-or-file>
+//	errstack diff <old-file> <new-file>
+//	errstack [-stacks stacks.jsonl] symbolize -binary ./server [hash]
+//	errstack regressions [-factor 2] <old-file> <new-file>
+//	errstack [-stacks stacks.jsonl] regressions [-factor 2] -old-revision <rev> -new-revision <rev>
+//
+// Every format the manager can write is supported, the format is detected automatically.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/enhanced-tools/errors"
+)
+
+const usage = `usage: errstack [-stacks path] <command> [args]
+
+commands:
+  show <hash>             print the stack trace saved under the hash
+  list                    list saved stack traces with counts and first seen time
+  grep <func-or-file>     list stack traces with a frame matching the function or file
+  diff <old> <new>        list stack hashes added and removed between two stack stores
+  symbolize -binary <binary> [hash]
+                          resolve raw stack traces against the binary which saved them
+  regressions [-factor n] <old> <new>
+  regressions [-factor n] -old-revision <rev> -new-revision <rev>
+                          report stack traces which appeared, disappeared or changed frequency
+                          between two stack stores or two revisions saved in one stack store,
+                          fails when new stack traces appeared
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "errstack: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("errstack", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	stacksPath := flags.String("stacks", "stacks.txt", "path to the stack file or directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing command")
+	}
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "show":
+		if len(commandArgs) != 1 {
+			return fmt.Errorf("show expects a single hash")
+		}
+		return withStore(*stacksPath, func(store errors.StackStore) error {
+			return show(out, store, commandArgs[0])
+		})
+	case "list":
+		return withStore(*stacksPath, func(store errors.StackStore) error {
+			return list(out, store, func(errors.StackEntry) bool { return true })
+		})
+	case "grep":
+		if len(commandArgs) != 1 {
+			return fmt.Errorf("grep expects a single function or file name")
+		}
+		return withStore(*stacksPath, func(store errors.StackStore) error {
+			return list(out, store, func(entry errors.StackEntry) bool {
+				return matchesFrame(entry, commandArgs[0])
+			})
+		})
+	case "diff":
+		if len(commandArgs) != 2 {
+			return fmt.Errorf("diff expects old and new stack file")
+		}
+		return withStore(commandArgs[0], func(oldStore errors.StackStore) error {
+			return withStore(commandArgs[1], func(newStore errors.StackStore) error {
+				return diff(out, oldStore, newStore)
+			})
+		})
+	case "symbolize":
+		return symbolizeCommand(out, *stacksPath, commandArgs)
+	case "regressions":
+		return regressionsCommand(out, *stacksPath, commandArgs)
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown command %s", command)
+}
+
+// withStore opens the existing stack store for reading, detecting its format
+func withStore(path string, fn func(store errors.StackStore) error) error {
+	format, err := errors.DetectStackFormat(path)
+	if err != nil {
+		return err
+	}
+	store, err := errors.OpenStackStoreReadOnly(path, format)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return fn(store)
+}
+
+func show(out io.Writer, store errors.StackStore, hash string) error {
+	entry, ok, err := store.Get(hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("stack %s not found", hash)
+	}
+	fmt.Fprintf(out, ">>> %s\n", entry.Hash)
+	if entry.Count > 0 {
+		fmt.Fprintf(out, "count: %d\n", entry.Count)
+	}
+	if !entry.FirstSeen.IsZero() {
+		fmt.Fprintf(out, "first seen: %s\n", entry.FirstSeen.Format(time.RFC3339))
+		fmt.Fprintf(out, "last seen: %s\n", entry.LastSeen.Format(time.RFC3339))
+	}
+	for _, field := range []struct{ name, value string }{
+		{"message", entry.Message},
+		{"template", entry.Template},
+		{"revision", entry.Revision},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(out, "%s: %s\n", field.name, field.value)
+		}
+	}
+	fmt.Fprint(out, entry.Format())
+	return nil
+}
+
+func list(out io.Writer, store errors.StackStore, filter func(errors.StackEntry) bool) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tCOUNT\tFIRST SEEN\tFRAME")
+	err := store.Each(func(entry errors.StackEntry) error {
+		if !filter(entry) {
+			return nil
+		}
+		count, firstSeen := "-", "-"
+		if entry.Count > 0 {
+			count = fmt.Sprintf("%d", entry.Count)
+		}
+		if !entry.FirstSeen.IsZero() {
+			firstSeen = entry.FirstSeen.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Hash, count, firstSeen, topFrame(entry))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// matchesFrame reports whether any frame of the stack trace contains the pattern in its function or file name
+func matchesFrame(entry errors.StackEntry, pattern string) bool {
+	if len(entry.Frames) == 0 {
+		return strings.Contains(entry.Format(), pattern)
+	}
+	for _, frame := range entry.Frames {
+		if strings.Contains(frame.FunctionName, pattern) || strings.Contains(frame.SourceFile, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashes(store errors.StackStore) (map[string]errors.StackEntry, []string, error) {
+	entries := make(map[string]errors.StackEntry)
+	var order []string
+	err := store.Each(func(entry errors.StackEntry) error {
+		entries[entry.Hash] = entry
+		order = append(order, entry.Hash)
+		return nil
+	})
+	return entries, order, err
+}
+
+func diff(out io.Writer, oldStore, newStore errors.StackStore) error {
+	oldEntries, oldOrder, err := hashes(oldStore)
+	if err != nil {
+		return err
+	}
+	newEntries, newOrder, err := hashes(newStore)
+	if err != nil {
+		return err
+	}
+	for _, hash := range newOrder {
+		if _, ok := oldEntries[hash]; !ok {
+			fmt.Fprintf(out, "+ %s %s\n", hash, topFrame(newEntries[hash]))
+		}
+	}
+	for _, hash := range oldOrder {
+		if _, ok := newEntries[hash]; !ok {
+			fmt.Fprintf(out, "- %s %s\n", hash, topFrame(oldEntries[hash]))
+		}
+	}
+	return nil
+}
+
+func symbolizeCommand(out io.Writer, stacksPath string, args []string) error {
+	flags := flag.NewFlagSet("symbolize", flag.ContinueOnError)
+	binary := flags.String("binary", "", "path to the binary which saved the stack traces")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *binary == "" || flags.NArg() > 1 {
+		return fmt.Errorf("symbolize expects -binary and an optional hash")
+	}
+	symbolizer, err := errors.NewSymbolizer(*binary)
+	if err != nil {
+		return err
+	}
+	return withStore(stacksPath, func(store errors.StackStore) error {
+		if flags.NArg() == 1 {
+			entry, ok, err := store.Get(flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("stack %s not found", flags.Arg(0))
+			}
+			return symbolize(out, symbolizer, entry)
+		}
+		return store.Each(func(entry errors.StackEntry) error {
+			if len(entry.PCs) == 0 {
+				return nil
+			}
+			return symbolize(out, symbolizer, entry)
+		})
+	})
+}
+
+func symbolize(out io.Writer, symbolizer *errors.Symbolizer, entry errors.StackEntry) error {
+	entry, err := symbolizer.Symbolize(entry)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, ">>> %s\n%s", entry.Hash, entry.Format())
+	return nil
+}
+
+func regressionsCommand(out io.Writer, stacksPath string, args []string) error {
+	flags := flag.NewFlagSet("regressions", flag.ContinueOnError)
+	factor := flags.Float64("factor", 2, "report stack traces whose share of all occurrences changed by the factor, 0 disables it")
+	oldRevision := flags.String("old-revision", "", "revision of the old release")
+	newRevision := flags.String("new-revision", "", "revision of the new release")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var comparison errors.StackComparison
+	var err error
+	switch {
+	case *oldRevision != "" && *newRevision != "" && flags.NArg() == 0:
+		err = withStore(stacksPath, func(store errors.StackStore) error {
+			comparison, err = errors.CompareRevisions(store, *oldRevision, *newRevision, *factor)
+			return err
+		})
+	case *oldRevision == "" && *newRevision == "" && flags.NArg() == 2:
+		err = withStore(flags.Arg(0), func(oldStore errors.StackStore) error {
+			return withStore(flags.Arg(1), func(newStore errors.StackStore) error {
+				comparison, err = errors.CompareStacks(oldStore, newStore, *factor)
+				return err
+			})
+		})
+	default:
+		return fmt.Errorf("regressions expects old and new stack file, or old and new revision")
+	}
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tHASH\tOLD\tNEW\tFRAME")
+	for _, group := range []struct {
+		mark    string
+		changes []errors.StackChange
+	}{
+		{"+", comparison.Added},
+		{"-", comparison.Removed},
+		{"~", comparison.Changed},
+	} {
+		for _, change := range group.changes {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", group.mark, change.Hash, change.OldCount, change.NewCount, topFrame(change.Entry))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(comparison.Added) > 0 {
+		return fmt.Errorf("%d new stack traces", len(comparison.Added))
+	}
+	return nil
+}
+
+func topFrame(entry errors.StackEntry) string {
+	if len(entry.Frames) == 0 {
+		return "-"
+	}
+	return entry.Frames[0].FunctionName
+}
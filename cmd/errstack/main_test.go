@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func saveStacks(t *testing.T, path string, format errors.StackFormat, errs ...errors.EnhancedError) {
+	store, err := errors.OpenStackStore(path, format)
+	assert.NoError(t, err)
+	for _, e := range errs {
+		assert.NoError(t, store.Put(errors.StackEntry{
+			Hash:  e.GetStackTraceHash(),
+			Trace: errors.MultilineStackTraceFormatter(e.GetStackTrace()),
+			Count: 1,
+		}))
+	}
+	assert.NoError(t, store.Close())
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	err1 := errors.New("first")
+	err2 := errors.New("second")
+	oldPath, newPath := filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.jsonl")
+	saveStacks(t, oldPath, errors.StackFormatText, err1)
+	saveStacks(t, newPath, errors.StackFormatJSONL, err1, err2)
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"-stacks", newPath, "show", err2.GetStackTraceHash()}, &out))
+	assert.True(t, strings.HasPrefix(out.String(), ">>> "+err2.GetStackTraceHash()+"\ncount: 1\n"))
+	assert.Contains(t, out.String(), errors.MultilineStackTraceFormatter(err2.GetStackTrace()))
+
+	out.Reset()
+	assert.NoError(t, run([]string{"-stacks", oldPath, "list"}, &out))
+	assert.Contains(t, out.String(), err1.GetStackTraceHash()+"  -      -           github.com/enhanced-tools/errors.New")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"-stacks", newPath, "grep", "TestCommands"}, &out))
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+	out.Reset()
+	assert.NoError(t, run([]string{"-stacks", newPath, "grep", "missing_function"}, &out))
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+
+	out.Reset()
+	assert.NoError(t, run([]string{"diff", oldPath, newPath}, &out))
+	assert.Equal(t, "+ "+err2.GetStackTraceHash()+" github.com/enhanced-tools/errors.New\n", out.String())
+
+	assert.Error(t, run([]string{"-stacks", newPath, "show", "missing"}, &out))
+	assert.Error(t, run([]string{"-stacks", newPath, "symbolize"}, &out))
+
+	out.Reset()
+	assert.EqualError(t, run([]string{"regressions", "-factor", "0", oldPath, newPath}, &out), "1 new stack traces")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []string{"+", err2.GetStackTraceHash(), "0", "1", "github.com/enhanced-tools/errors.New"}, strings.Fields(lines[1]))
+	assert.NoError(t, run([]string{"regressions", newPath, newPath}, &out))
+}
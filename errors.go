@@ -1,11 +1,11 @@
 package errors
 
 import (
-	"bytes"
-	"crypto/md5"
 	"fmt"
 	"reflect"
-	"unsafe"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -25,25 +25,45 @@ type ErrorOpt interface {
 type enhancedError struct {
 	error
 	TemplateID string
-	ErrorID    string
-	Opts       map[ErrorOptType]ErrorOpt
+	// TemplateParents holds IDs of the templates the error template was derived from, starting with the root one
+	TemplateParents []string
+	ErrorID         string
+	// ParentIDs holds IDs of the errors this one was created from with From, starting with the oldest one
+	ParentIDs []string
+	Opts      map[ErrorOptType]ErrorOpt
+	// Wraps holds messages added with Wrap, starting with the outermost one
+	Wraps []WrapFrame
+	// Cause is the enhanced error the error was created from with From. It keeps its own options and wrappers.
+	Cause *enhancedError
+	// manager is the manager the error is bound to. Unbound errors use the default manager.
+	manager ErrorsManager
+	// call collects failures of the logger the error is being logged with
+	call *logCall
 }
 
 type EnhancedError interface {
 	error
 	// With adds an option to the error. If the option already exists (by checking its type), it will be overwritten.
 	With(opts ...ErrorOpt) EnhancedError
-	// From returns a enhanced error from common one. If the error is already enhanced, it will be returned as copy of previous one.
+	// From returns a enhanced error from common one. If the error is already enhanced, it is kept as a cause of the new one.
 	From(err error) EnhancedError
 	// FromEmpty returns a new enhanced error from nothing. It is used with Template() function to mark proper stack trace.
 	FromEmpty() EnhancedError
+	// Derive creates a child template. Errors created from the child match both the child and all its ancestors with Is.
+	Derive(name string) EnhancedError
 
-	// Log logs the error using the default logger or the loggers specified in the function. Loggers must be registered before either the program will panic.
+	// Log logs the error using the default logger or the loggers specified in the function. Missing loggers are handled
+	// according to the missing logger policy of the manager, by default the program panics.
 	Log(loggers ...LogName)
+	// LogE logs the error like Log, but never panics. It returns the first missing logger or logger failure.
+	LogE(loggers ...LogName) error
 	// Is checks if the error is of the same type as the one specified. It will check the template ID and the error ID if comparing enhanced errors.
 	Is(err error) bool
-	// Wrap wraps the error with a message. Is is used as a shortcut for With(Wrapper(msg))
-	Wrap(msg string) EnhancedError
+	// Wrap wraps the error with a message. The caller location and optional options are recorded along with the message.
+	Wrap(msg string, opts ...ErrorOpt) EnhancedError
+	// Bind returns a copy of the error bound to the manager, which is used for logging and fingerprinting. Errors created
+	// from a bound template are bound to the same manager.
+	Bind(m ErrorsManager) EnhancedError
 
 	// GetStackTrace returns the stack trace of the error.
 	GetStackTrace() errors.StackTrace
@@ -51,12 +71,24 @@ type EnhancedError interface {
 	GetStackTraceHash() string
 	// GetInternalError returns the internal error.
 	GetInternalError() error
+	// GetWraps returns messages added with Wrap, starting with the outermost one.
+	GetWraps() []WrapFrame
+	// GetCause returns the enhanced error this error was created from with From, or nil.
+	GetCause() EnhancedError
 	// GetOpts returns the options of the error.
 	GetOpts() map[ErrorOptType]ErrorOpt
 	// GetOpt sets error opt value
 	GetOpt(opt ErrorOpt) bool
-	// GetErrorID returns the error ID of the error.
+	// GetErrorID returns the error ID of the error. It stays the same when the error is enriched with With or Wrap.
 	GetErrorID() string
+	// GetParentIDs returns IDs of the errors this one was created from with From, starting with the oldest one.
+	GetParentIDs() []string
+	// GetTemplateID returns the ID of the template the error was created from. For named templates it is the template name.
+	GetTemplateID() string
+	// GetTemplatePath returns IDs of the template ancestry, starting with the root template and ending with the own template ID.
+	GetTemplatePath() []string
+	// GetManager returns the manager the error is bound to, or the default manager.
+	GetManager() ErrorsManager
 }
 
 func copyOpts(opts map[ErrorOptType]ErrorOpt) map[ErrorOptType]ErrorOpt {
@@ -99,52 +131,110 @@ func Newf(msg string, data ...interface{}) EnhancedError {
 
 func (e enhancedError) With(opts ...ErrorOpt) EnhancedError {
 	newOpts := copyOpts(e.Opts)
+	var wrappers []Wrapper
 	for _, opt := range opts {
-		if existingWrapper, ok := e.Opts["wrapper"]; opt.Type() == "wrapper" && ok {
-			existingWrapper := existingWrapper.(Wrapper)
-			newWrapper := opt.(Wrapper)
-			newOpts["wrapper"] = newWrapper + existingWrapper
+		if wrapper, ok := opt.(Wrapper); ok {
+			wrappers = append(wrappers, wrapper)
 		} else {
 			newOpts[opt.Type()] = opt
 		}
 	}
-	return &enhancedError{
-		ErrorID:    uuid.NewString(),
-		TemplateID: e.TemplateID,
-		error:      e.error,
-		Opts:       newOpts,
+	newErr := e.withOpts(newOpts)
+	for _, wrapper := range wrappers {
+		newErr.Wraps = newErr.wrapFrames(1, string(wrapper), nil)
 	}
+	return newErr
+}
+
+// withOpts returns a copy of the error with the options replaced. The copy keeps the error ID.
+func (e enhancedError) withOpts(opts map[ErrorOptType]ErrorOpt) *enhancedError {
+	e.Opts = opts
+	return &e
 }
 
 func Wrap(err error, msg string) EnhancedError {
-	return Enhance(err).Wrap(msg)
+	return wrap(Enhance(err), msg)
 }
 
 func Wrapf(err error, msg string, data ...interface{}) EnhancedError {
-	return Wrap(err, fmt.Sprintf(msg, data...))
+	return wrap(Enhance(err), fmt.Sprintf(msg, data...))
 }
 
-func Template() EnhancedError {
+// wrap wraps the error recording the location of the caller of the function calling wrap
+func wrap(e EnhancedError, msg string) EnhancedError {
+	if e == nil {
+		return nil
+	}
+	if enErr, ok := e.(*enhancedError); ok {
+		newErr := enErr.withOpts(copyOpts(enErr.Opts))
+		newErr.Wraps = enErr.wrapFrames(2, msg, nil)
+		return newErr
+	}
+	return e.Wrap(msg)
+}
+
+var templates = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+func registerTemplate(name string) {
+	templates.Lock()
+	defer templates.Unlock()
+	if templates.names[name] {
+		panic(fmt.Sprintf("Template %s already registered", name))
+	}
+	templates.names[name] = true
+}
+
+// Template creates a new error template. The name is optional, when given it is used as a stable template ID
+// which stays the same across restarts, services and serialization. Names must be unique, registering the same name twice panics.
+func Template(name ...string) EnhancedError {
+	templateID := uuid.NewString()
+	if len(name) > 0 && name[0] != "" {
+		templateID = name[0]
+		registerTemplate(templateID)
+	}
 	return &enhancedError{
-		TemplateID: uuid.NewString(),
+		TemplateID: templateID,
 		Opts:       make(map[ErrorOptType]ErrorOpt),
 	}
 }
 
+func (e enhancedError) Derive(name string) EnhancedError {
+	templateID := name
+	if e.TemplateID != "" {
+		templateID = fmt.Sprintf("%s.%s", e.TemplateID, name)
+	}
+	registerTemplate(templateID)
+	return &enhancedError{
+		TemplateID:      templateID,
+		TemplateParents: e.GetTemplatePath(),
+		Opts:            copyOpts(e.Opts),
+		manager:         e.manager,
+	}
+}
+
 func (e enhancedError) From(err error) EnhancedError {
 	if enErr, ok := err.(*enhancedError); ok {
 		return &enhancedError{
-			ErrorID:    uuid.NewString(),
-			TemplateID: e.TemplateID,
-			error:      enErr.error,
-			Opts:       copyOpts(e.Opts),
+			ErrorID:         uuid.NewString(),
+			ParentIDs:       append(append([]string{}, enErr.ParentIDs...), enErr.ErrorID),
+			TemplateID:      e.TemplateID,
+			TemplateParents: e.TemplateParents,
+			error:           enErr.error,
+			Opts:            copyOpts(e.Opts),
+			Cause:           enErr,
+			manager:         e.manager,
 		}
 	}
 	return &enhancedError{
-		ErrorID:    uuid.NewString(),
-		TemplateID: e.TemplateID,
-		error:      errors.WithStack(err),
-		Opts:       copyOpts(e.Opts),
+		ErrorID:         uuid.NewString(),
+		TemplateID:      e.TemplateID,
+		TemplateParents: e.TemplateParents,
+		error:           errors.WithStack(err),
+		Opts:            copyOpts(e.Opts),
+		manager:         e.manager,
 	}
 }
 
@@ -153,33 +243,60 @@ func (e enhancedError) FromEmpty() EnhancedError {
 }
 
 func (e enhancedError) Log(loggers ...LogName) {
-	if len(loggers) == 0 {
-		loggers = []LogName{DefaultLog}
-	}
-	for _, logger := range loggers {
-		logF, ok := errManager.loggers[logger]
-		if !ok {
-			panic(fmt.Sprintf("Logger %s not found", logger))
-		}
-		logF(e)
+	e.GetManager().Log(&e, loggers...)
+}
+
+func (e enhancedError) LogE(loggers ...LogName) error {
+	return e.GetManager().LogE(&e, loggers...)
+}
+
+func (e enhancedError) Bind(m ErrorsManager) EnhancedError {
+	e.manager = m
+	return &e
+}
+
+func (e enhancedError) GetManager() ErrorsManager {
+	if e.manager != nil {
+		return e.manager
 	}
+	return Manager()
 }
 
 func (e enhancedError) Is(err error) bool {
 	if errn, ok := err.(*enhancedError); ok {
-		if errn.TemplateID != "" {
-			return e.TemplateID == errn.TemplateID
-		} else {
-			return e.ErrorID == errn.ErrorID
+		if errn.TemplateID != "" && e.hasTemplate(errn.TemplateID) {
+			return true
+		} else if errn.TemplateID == "" && e.ErrorID == errn.ErrorID {
+			return true
 		}
+		if e.Cause != nil {
+			return e.Cause.Is(err)
+		}
+		return false
 	}
 	return errors.Is(e.error, err)
 }
 
+func (e enhancedError) hasTemplate(templateID string) bool {
+	if e.TemplateID == templateID {
+		return true
+	}
+	for _, parentID := range e.TemplateParents {
+		if parentID == templateID {
+			return true
+		}
+	}
+	return false
+}
+
 func (e enhancedError) Unwrap() error {
+	if e.Cause != nil {
+		return e.Cause
+	}
 	return e.GetInternalError()
 }
 
+// Wrapper is an option that wraps the error with a message when passed to With. It is equivalent to Wrap(msg).
 type Wrapper string
 
 func (Wrapper) Type() ErrorOptType {
@@ -194,21 +311,38 @@ func (Wrapper) Verbosity() int {
 	return 0
 }
 
-func (e enhancedError) Wrap(msg string) EnhancedError {
-	opts := copyOpts(e.Opts)
-	wrapper := Wrapper(msg)
-	if existingWrapper, ok := e.Opts[wrapper.Type()]; ok {
-		existingWrapper := existingWrapper.(Wrapper)
-		opts[wrapper.Type()] = wrapper + existingWrapper
-	} else {
-		opts[wrapper.Type()] = wrapper
+// WrapFrame is a single message added to the error with Wrap
+type WrapFrame struct {
+	Message string
+	// File and Line point to the place where Wrap was called
+	File string
+	Line int
+	Opts map[ErrorOptType]ErrorOpt
+}
+
+func (e enhancedError) Wrap(msg string, opts ...ErrorOpt) EnhancedError {
+	newErr := e.withOpts(copyOpts(e.Opts))
+	newErr.Wraps = e.wrapFrames(1, msg, opts)
+	return newErr
+}
+
+// wrapFrames returns wrap frames of the error with a new outermost frame. Skip is the number of stack frames
+// to skip above the caller of wrapFrames to reach the wrapping location.
+func (e enhancedError) wrapFrames(skip int, msg string, opts []ErrorOpt) []WrapFrame {
+	frame := WrapFrame{Message: msg}
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		frame.File = file
+		frame.Line = line
 	}
-	return &enhancedError{
-		ErrorID:    uuid.NewString(),
-		TemplateID: e.TemplateID,
-		error:      e.error,
-		Opts:       opts,
+	if len(opts) > 0 {
+		frame.Opts = make(map[ErrorOptType]ErrorOpt)
+		for _, opt := range opts {
+			frame.Opts[opt.Type()] = opt
+		}
 	}
+	wraps := make([]WrapFrame, 0, len(e.Wraps)+1)
+	wraps = append(wraps, frame)
+	return append(wraps, e.Wraps...)
 }
 
 type stackTracer interface {
@@ -222,25 +356,27 @@ func (e enhancedError) GetStackTrace() errors.StackTrace {
 	return nil
 }
 
-const sizeOfUintPtr = unsafe.Sizeof(uintptr(0))
-
-func uintptrToBytes(u *errors.Frame) []byte {
-	return (*[sizeOfUintPtr]byte)(unsafe.Pointer(u))[:]
-}
-
 func (e enhancedError) GetStackTraceHash() string {
-	st := e.GetStackTrace()
-	buffer := bytes.Buffer{}
-	for _, f := range st {
-		buffer.Write(uintptrToBytes((&f)))
-	}
-	return fmt.Sprintf("%x", md5.Sum(buffer.Bytes()))
+	return e.GetManager().Fingerprint(e.GetStackTrace())
 }
 
 func (e enhancedError) GetInternalError() error {
 	return e.error
 }
 
+func (e enhancedError) GetWraps() []WrapFrame {
+	wraps := make([]WrapFrame, len(e.Wraps))
+	copy(wraps, e.Wraps)
+	return wraps
+}
+
+func (e enhancedError) GetCause() EnhancedError {
+	if e.Cause == nil {
+		return nil
+	}
+	return e.Cause
+}
+
 func (e enhancedError) GetOpts() map[ErrorOptType]ErrorOpt {
 	return copyOpts(e.Opts)
 }
@@ -263,11 +399,40 @@ func (e enhancedError) GetErrorID() string {
 	return e.ErrorID
 }
 
+func (e enhancedError) GetParentIDs() []string {
+	parentIDs := make([]string, len(e.ParentIDs))
+	copy(parentIDs, e.ParentIDs)
+	return parentIDs
+}
+
+func (e enhancedError) GetTemplateID() string {
+	return e.TemplateID
+}
+
+func (e enhancedError) GetTemplatePath() []string {
+	if e.TemplateID == "" {
+		return nil
+	}
+	path := make([]string, 0, len(e.TemplateParents)+1)
+	path = append(path, e.TemplateParents...)
+	return append(path, e.TemplateID)
+}
+
 func (e enhancedError) Error() string {
-	var wrapper Wrapper
-	value, ok := e.GetOpts()[wrapper.Type()]
-	if !ok {
-		return e.GetInternalError().Error()
+	if len(e.Wraps) == 0 {
+		return e.message()
+	}
+	messages := make([]string, 0, len(e.Wraps)+1)
+	for _, frame := range e.Wraps {
+		messages = append(messages, frame.Message)
+	}
+	return strings.Join(append(messages, e.message()), ": ")
+}
+
+// message returns the error message without own wrappers. Messages of the cause layers are included.
+func (e enhancedError) message() string {
+	if e.Cause != nil {
+		return e.Cause.Error()
 	}
-	return fmt.Sprintf("%s: %s", value, e.GetInternalError())
+	return e.GetInternalError().Error()
 }
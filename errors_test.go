@@ -1,15 +1,34 @@
 package errors_test
 
 import (
+	"context"
+	"encoding/json"
 	stderrors "errors"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/enhanced-tools/errors"
 	"github.com/enhanced-tools/errors/opts"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
+// Named templates are registered once per test binary, so they are declared as globals
+var (
+	errTestNamed      = errors.Template("test.named_template")
+	errTestDuplicated = errors.Template("test.duplicated_template")
+	errTestClient     = errors.Template("test.client")
+	errTestNotFound   = errTestClient.Derive("not_found")
+	errTestConflict   = errTestClient.Derive("conflict")
+	errTestRepository = errors.Template("test.repository").With(opts.Debug("query"))
+	errTestService    = errors.Template("test.service").With(opts.Title("service failed"))
+)
+
 func TestNewf(t *testing.T) {
 	err := errors.Newf("%s %d", "t", 1)
 	msg := err.Error()
@@ -58,13 +77,244 @@ func TestMultilineError(t *testing.T) {
 
 func TestLogFMTError(t *testing.T) {
 	err := fmt.Errorf("Orginal error")
-	errors.Manager().Setup("./stacks.txt")
-	errors.Manager().SetDefaultLogger(errors.CustomLogger(
+	manager := errors.NewManager()
+	assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.txt")))
+	defer manager.Reset()
+	manager.SetDefaultLogger(errors.CustomLogger(
 		errors.WithErrorFormatter(errors.LogFMTFormatter),
 		errors.WithVerbosity(200),
 		errors.WithStackTraceFormatter(errors.NoStackTrace),
 		errors.WithSaveStack(true),
 	))
-	enhanced := errors.Enhance(err).With(opts.Debug("John"), opts.Title("Smoth"))
+	enhanced := errors.Enhance(err).With(opts.Debug("John"), opts.Title("Smoth")).Bind(manager)
 	enhanced.Log()
+	ok, storeErr := manager.StackStore().Has(enhanced.GetStackTraceHash())
+	assert.NoError(t, storeErr)
+	assert.True(t, ok)
+}
+
+func TestBoundManager(t *testing.T) {
+	var logged []string
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(func(err errors.EnhancedError) { logged = append(logged, "default:"+err.Error()) }),
+		errors.WithLogger("audit", func(err errors.EnhancedError) { logged = append(logged, "audit:"+err.Error()) }),
+		errors.WithFingerprinter(func(st pkgerrors.StackTrace) string { return "fixed" }),
+	)
+	tmpl := errors.Template().Bind(manager)
+	err := tmpl.From(fmt.Errorf("bound")).Wrap("wrapped")
+	err.Log()
+	err.Log("audit")
+	assert.Equal(t, []string{"default:wrapped: bound", "audit:wrapped: bound"}, logged)
+	assert.Equal(t, "fixed", err.GetStackTraceHash())
+	assert.Equal(t, manager, err.GetManager())
+
+	unbound := errors.New("unbound")
+	assert.Equal(t, errors.Manager(), unbound.GetManager())
+	assert.NotEqual(t, "fixed", unbound.GetStackTraceHash())
+	assert.Panics(t, func() { unbound.Log("audit") })
+
+	ctx := errors.ContextWithManager(context.Background(), manager)
+	assert.Equal(t, manager, errors.ManagerFromContext(ctx))
+	assert.Equal(t, errors.Manager(), errors.ManagerFromContext(context.Background()))
+
+	assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.txt")))
+	assert.Error(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.txt")))
+	assert.NoError(t, manager.Reset())
+	assert.Nil(t, manager.StackStore())
+	assert.NotEqual(t, "fixed", err.GetStackTraceHash())
+	assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.txt")))
+	assert.NoError(t, manager.Reset())
+}
+
+func TestNamedTemplateSurvivesJSON(t *testing.T) {
+	tmpl := errTestNamed
+	err := tmpl.With(opts.Title("named")).FromEmpty()
+	assert.Equal(t, "test.named_template", err.GetTemplateID())
+
+	decoded, decodeErr := errors.FromJSON(errors.AsJSON(err))
+	assert.NoError(t, decodeErr)
+	assert.Equal(t, err.GetErrorID(), decoded.GetErrorID())
+	assert.Equal(t, err.Error(), decoded.Error())
+	assert.True(t, stderrors.Is(decoded, tmpl), "decoded error should match named template")
+}
+
+func TestNamedTemplateDuplicatePanics(t *testing.T) {
+	assert.Equal(t, "test.duplicated_template", errTestDuplicated.GetTemplateID())
+	assert.Panics(t, func() {
+		errors.Template("test.duplicated_template")
+	})
+}
+
+func TestDerivedTemplateIs(t *testing.T) {
+	errClient, errNotFound, errConflict := errTestClient, errTestNotFound, errTestConflict
+	assert.Equal(t, "test.client.not_found", errNotFound.GetTemplateID())
+	assert.Equal(t, []string{"test.client", "test.client.not_found"}, errNotFound.GetTemplatePath())
+
+	err := errNotFound.FromEmpty().With(opts.Title("missing"))
+	assert.True(t, stderrors.Is(err, errClient), "derived error should match parent template")
+	assert.True(t, stderrors.Is(err, errNotFound))
+	assert.False(t, stderrors.Is(err, errConflict), "derived error should not match sibling template")
+	assert.False(t, stderrors.Is(errClient.FromEmpty(), errNotFound), "parent error should not match child template")
+
+	decoded, decodeErr := errors.FromJSON(errors.AsJSON(errNotFound.From(err)))
+	assert.NoError(t, decodeErr)
+	assert.True(t, stderrors.Is(decoded, errClient), "decoded error should keep template ancestry")
+	assert.True(t, stderrors.Is(decoded, errNotFound))
+	assert.False(t, stderrors.Is(decoded, errConflict))
+	assert.Equal(t, []string{err.GetErrorID()}, decoded.GetParentIDs())
+}
+
+func TestFromJSONKeepsContent(t *testing.T) {
+	err := errors.Wrap(fmt.Errorf("no rows"), "loading user")
+	decoded, decodeErr := errors.FromJSON(errors.AsJSON(err))
+	assert.NoError(t, decodeErr)
+	assert.Equal(t, "loading user: no rows", decoded.Error())
+}
+
+func TestFromKeepsEnhancedCause(t *testing.T) {
+	errRepository, errService := errTestRepository, errTestService
+
+	inner := errRepository.From(fmt.Errorf("no rows")).Wrap("loading user")
+	outer := errService.From(inner)
+	assert.Equal(t, "loading user: no rows", outer.Error())
+	assert.Equal(t, inner, outer.GetCause())
+	assert.True(t, outer.Is(errRepository), "outer error should match template of the cause")
+	assert.True(t, stderrors.Is(outer, errRepository))
+	assert.True(t, stderrors.Is(outer, errService))
+
+	payload := string(errors.AsJSON(outer))
+	assert.Contains(t, payload, `"cause":{`)
+	assert.Contains(t, payload, `"template":"test.repository"`)
+	assert.Contains(t, errors.LogFMTFormatter(outer, 100, errors.NoStackTrace), "cause.template=test.repository")
+	assert.Contains(t, errors.MultilineFormatter(outer, 100, errors.NoStackTrace), "CAUSED BY: "+inner.GetErrorID())
+}
+
+func TestWrapFrames(t *testing.T) {
+	err := errors.Wrap(fmt.Errorf("inner"), "middle")
+	_, file, line, _ := runtime.Caller(0)
+	err = err.Wrap("outer", opts.Debug("id")).With(errors.Wrapper("top"))
+	assert.Equal(t, "top: outer: middle: inner", err.Error())
+
+	wraps := err.GetWraps()
+	assert.Len(t, wraps, 3)
+	assert.Equal(t, "middle", wraps[2].Message)
+	assert.Equal(t, file, wraps[2].File)
+	assert.Equal(t, line-1, wraps[2].Line)
+	assert.Equal(t, line+1, wraps[1].Line)
+	assert.Contains(t, wraps[1].Opts, opts.Debug("id").Type())
+	assert.Contains(t, errors.MultilineFormatter(err, 100, errors.NoStackTrace), fmt.Sprintf("outer at %s:%d", file, line+1))
+
+	var payload struct {
+		Wraps []struct {
+			Message  string                 `json:"message"`
+			Location string                 `json:"location"`
+			Opts     map[string]interface{} `json:"opts"`
+		} `json:"wraps"`
+	}
+	assert.NoError(t, json.Unmarshal(errors.AsJSON(err), &payload))
+	if assert.Len(t, payload.Wraps, 3) {
+		assert.Equal(t, "outer", payload.Wraps[1].Message)
+		assert.Equal(t, fmt.Sprintf("%s:%d", file, line+1), payload.Wraps[1].Location)
+		assert.NotEmpty(t, payload.Wraps[1].Opts)
+	}
+	assert.NotContains(t, string(errors.AsJSON(errors.New("plain"))), `"wraps"`)
+}
+
+func TestErrorIDStableAcrossEnrichment(t *testing.T) {
+	err := errors.New("base")
+	enriched := err.With(opts.RequestID("req")).Wrap("handling").With(opts.StatusCode(500))
+	assert.Equal(t, err.GetErrorID(), enriched.GetErrorID())
+	assert.True(t, enriched.Is(err), "enriched error should match the original one")
+	assert.True(t, err.Is(enriched))
+
+	retemplated := errors.Template().From(enriched)
+	assert.NotEqual(t, enriched.GetErrorID(), retemplated.GetErrorID())
+	assert.Equal(t, []string{err.GetErrorID()}, retemplated.GetParentIDs())
+	again := errors.Template().From(retemplated)
+	assert.Equal(t, []string{err.GetErrorID(), retemplated.GetErrorID()}, again.GetParentIDs())
+	assert.Contains(t, string(errors.AsJSON(again)), `"parentIDs":["`+err.GetErrorID())
+}
+
+func TestSymbolFingerprint(t *testing.T) {
+	err1 := errors.New("first")
+	err2 := errors.New("second")
+	assert.Equal(t, errors.SymbolFingerprint(err1.GetStackTrace()), errors.SymbolFingerprint(err2.GetStackTrace()))
+	assert.NotEqual(t, errors.SymbolLineFingerprint(err1.GetStackTrace()), errors.SymbolLineFingerprint(err2.GetStackTrace()))
+	assert.Equal(t, errors.SymbolLineFingerprint(err1.GetStackTrace()), err1.GetStackTraceHash())
+
+	errors.Manager().SetFingerprinter(errors.PCFingerprint)
+	defer errors.Manager().SetFingerprinter(errors.SymbolLineFingerprint)
+	assert.Equal(t, errors.PCFingerprint(err1.GetStackTrace()), err1.GetStackTraceHash())
+}
+
+func TestSeverity(t *testing.T) {
+	assert.Equal(t, errors.LogError, errors.SeverityOf(errors.New("plain")))
+	assert.Equal(t, errors.LogInfo, errors.SeverityOf(errors.New("not found").With(opts.StatusCode(404))))
+	assert.Equal(t, errors.LogError, errors.SeverityOf(errors.New("bad gateway").With(opts.StatusCode(502))))
+	assert.Equal(t, errors.LogWarning, errors.SeverityOf(errors.New("denied").With(opts.StatusCode(403), opts.ErrNamePermissions)))
+	assert.Equal(t, errors.LogError, errors.SeverityOf(errors.New("failed").With(opts.StatusCode(400), opts.ErrNameInternal)))
+
+	tmpl := errors.Template().With(opts.StatusCode(503), opts.SeverityWarning)
+	err := tmpl.FromEmpty()
+	assert.Equal(t, errors.LogWarning, errors.SeverityOf(err))
+	assert.Equal(t, errors.LogDebug, errors.SeverityOf(err.With(opts.SeverityDebug)))
+
+	assert.Contains(t, errors.LogFMTFormatter(err, 100, errors.NoStackTrace), " level=warning ")
+	assert.Contains(t, string(errors.AsJSON(err)), `"level":"warning"`)
+	assert.Contains(t, errors.MultilineFormatter(err, 100, errors.NoStackTrace), "\tLEVEL: warning \n")
+	assert.NotContains(t, string(errors.AsJSON(err)), "severity")
+}
+
+func TestMinSeverity(t *testing.T) {
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	manager := errors.NewManager(errors.WithDefaultLogger(errors.CustomLogger(
+		errors.WithErrorFormatter(errors.LogFMTFormatter),
+		errors.WithStackTraceFormatter(errors.NoStackTrace),
+		errors.WithMinSeverity(errors.LogWarning),
+	)))
+	errors.New("expected").With(opts.StatusCode(404)).Bind(manager).Log()
+	errors.New("unexpected").With(opts.StatusCode(500)).Bind(manager).Log()
+	assert.NotContains(t, logs.String(), "content=expected")
+	assert.Contains(t, logs.String(), "content=unexpected")
+}
+
+func TestLoggerSinks(t *testing.T) {
+	var output, stdOutput strings.Builder
+	var records []string
+	err := errors.New("sink").With(opts.StatusCode(500))
+	manager := errors.NewManager(
+		errors.WithLogger("output", errors.CustomLogger(
+			errors.WithStreamFormatter(errors.WriteLogFMT),
+			errors.WithStackTraceFormatter(errors.NoStackTrace),
+			errors.WithOutput(&output),
+		)),
+		errors.WithLogger("std", errors.CustomLogger(
+			errors.WithStdLogger(log.New(&stdOutput, "errors: ", 0)),
+		)),
+		errors.WithLogger("sink", errors.CustomLogger(
+			errors.WithErrorFormatter(errors.LogFMTFormatter),
+			errors.WithSink(errors.SinkFunc(func(e errors.EnhancedError, record []byte) error {
+				records = append(records, string(record))
+				return fmt.Errorf("sink closed")
+			})),
+		)),
+		errors.WithSinkErrorHook(func(errors.EnhancedError, errors.LogName, error) {}),
+	)
+	err = err.Bind(manager)
+
+	assert.NoError(t, err.LogE("output", "output"))
+	record := errors.LogFMTFormatter(err, 0, errors.NoStackTrace)
+	assert.Equal(t, record+record, output.String())
+
+	assert.NoError(t, err.LogE("std"))
+	assert.Equal(t, "errors: "+errors.MultilineFormatter(err, 0, errors.MultilineStackTraceFormatter), stdOutput.String())
+
+	assert.EqualError(t, err.LogE("sink"), "writing error: sink closed")
+	assert.Equal(t, []string{errors.LogFMTFormatter(err, 0, errors.MultilineStackTraceFormatter)}, records)
+
+	var multiline strings.Builder
+	assert.NoError(t, errors.WriteMultiline(&multiline, err, 0, errors.NoStackTrace))
+	assert.Equal(t, errors.MultilineFormatter(err, 0, errors.NoStackTrace), multiline.String())
 }
@@ -7,11 +7,11 @@ replace github.com/enhanced-tools/errors => ../..
 require (
 	github.com/enhanced-tools/errors v0.0.0-00010101000000-000000000000
 	github.com/go-chi/chi/v5 v5.0.8
+	github.com/google/uuid v1.3.0
 )
 
 require (
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/logrusorgru/aurora v2.0.3+incompatible // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 )
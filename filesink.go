@@ -0,0 +1,363 @@
+package errors
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileSinkOpts struct {
+	maxSize        int64
+	maxAge         time.Duration
+	backups        int
+	compress       bool
+	reopenOnSIGHUP bool
+}
+
+type FileSinkOption func(*fileSinkOpts)
+
+// WithFileRotation rotates the log file when it grows over maxSize bytes or when it is older than maxAge.
+// Zero value disables the limit. With maxAge set, the time the log file was created is recorded in the hidden
+// .name.created file next to it, so its age survives restarts.
+func WithFileRotation(maxSize int64, maxAge time.Duration) FileSinkOption {
+	return func(o *fileSinkOpts) {
+		o.maxSize = maxSize
+		o.maxAge = maxAge
+	}
+}
+
+// WithFileBackups sets the number of rotated log files kept next to the current one. The default is 3.
+func WithFileBackups(backups int) FileSinkOption {
+	return func(o *fileSinkOpts) {
+		o.backups = backups
+	}
+}
+
+// WithFileCompression sets whether rotated log files are compressed with gzip. They are compressed by default.
+func WithFileCompression(compress bool) FileSinkOption {
+	return func(o *fileSinkOpts) {
+		o.compress = compress
+	}
+}
+
+// WithReopenOnSIGHUP sets whether the log file is reopened when the process receives SIGHUP, for example after
+// it was moved by logrotate. It is disabled by default, as the signal is handled by the sink instead of
+// terminating the process. It is supported on platforms having the signal.
+func WithReopenOnSIGHUP(reopen bool) FileSinkOption {
+	return func(o *fileSinkOpts) {
+		o.reopenOnSIGHUP = reopen
+	}
+}
+
+// FileSink is the sink appending errors to the log file. Rotated files are named path.1, path.2 and so on,
+// with the .gz suffix when compressed, path.1 being the newest one. Writes, rotation and reopening are serialized.
+// Rotated files are compressed in the background, Flush and Close wait for the compression to finish.
+type FileSink struct {
+	writerSink
+	file    *rotatingFile
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+// NewFileSink opens the log file, creating it when it does not exist. The sink should be closed when it is not used
+// anymore, it can be registered with RegisterFlusher to be flushed and closed by the manager.
+func NewFileSink(path string, opts ...FileSinkOption) (*FileSink, error) {
+	options := &fileSinkOpts{
+		backups:  3,
+		compress: true,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	file := &rotatingFile{path: path, opts: options}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	s := &FileSink{
+		writerSink: writerSink{w: NewWriter(file)},
+		file:       file,
+		done:       make(chan struct{}),
+	}
+	if options.reopenOnSIGHUP && len(reopenSignals) > 0 {
+		s.signals = make(chan os.Signal, 1)
+		signal.Notify(s.signals, reopenSignals...)
+		go s.reopenOnSignal()
+	}
+	return s, nil
+}
+
+func (s *FileSink) reopenOnSignal() {
+	for {
+		select {
+		case <-s.signals:
+			if err := s.Reopen(); err != nil && err != os.ErrClosed {
+				log.Print(fmt.Errorf("reopening error log file: %w", err))
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Reopen closes and opens the log file again, so a file moved by other tool is replaced with a new one
+func (s *FileSink) Reopen() error {
+	s.w.mu.Lock()
+	defer s.w.mu.Unlock()
+	if s.file.file == nil {
+		return os.ErrClosed
+	}
+	if err := s.file.file.Close(); err != nil {
+		return err
+	}
+	return s.file.open()
+}
+
+// Rotate rotates the log file regardless of the rotation limits
+func (s *FileSink) Rotate() error {
+	s.w.mu.Lock()
+	defer s.w.mu.Unlock()
+	if s.file.file == nil {
+		return os.ErrClosed
+	}
+	return s.file.rotate()
+}
+
+// Flush syncs the log file to disk and waits for the rotated file to be compressed
+func (s *FileSink) Flush(ctx context.Context) error {
+	s.w.mu.Lock()
+	if s.file.file == nil {
+		s.w.mu.Unlock()
+		return nil
+	}
+	err := s.file.file.Sync()
+	compressing := s.file.compressing
+	s.w.mu.Unlock()
+	if compressing == nil {
+		return err
+	}
+	select {
+	case <-compressing:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *FileSink) Close() error {
+	s.w.mu.Lock()
+	defer s.w.mu.Unlock()
+	if s.file.file == nil {
+		return nil
+	}
+	if s.signals != nil {
+		signal.Stop(s.signals)
+	}
+	close(s.done)
+	err := s.file.file.Close()
+	s.file.file = nil
+	s.file.waitCompression()
+	return err
+}
+
+// rotatingFile is the log file rotated by its size and age. It is not safe for concurrent use.
+type rotatingFile struct {
+	path string
+	opts *fileSinkOpts
+	file *os.File
+	size int64
+	// createdAt is the time the log file was created, as recorded in the created file
+	createdAt time.Time
+	// compressing is closed when the compression of the last rotated file finishes
+	compressing chan struct{}
+}
+
+func (f *rotatingFile) open() error {
+	_, statErr := os.Stat(f.path)
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.createdAt = time.Now()
+	if f.opts.maxAge > 0 {
+		f.trackCreated(info, os.IsNotExist(statErr))
+	}
+	return nil
+}
+
+// createdPath returns path of the hidden file recording when the log file was created
+func (f *rotatingFile) createdPath() string {
+	return filepath.Join(filepath.Dir(f.path), "."+filepath.Base(f.path)+".created")
+}
+
+// trackCreated records the creation time of the new log file, or reads it for the existing one. The recorded time
+// is ignored when it is newer than the last write, as the file was then replaced without the sink knowing.
+func (f *rotatingFile) trackCreated(info os.FileInfo, created bool) {
+	if created {
+		err := os.WriteFile(f.createdPath(), []byte(f.createdAt.UTC().Format(time.RFC3339Nano)), 0666)
+		if err != nil {
+			log.Print(fmt.Errorf("recording creation time of error log file: %w", err))
+		}
+		return
+	}
+	data, err := os.ReadFile(f.createdPath())
+	if err != nil {
+		return
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err == nil && !createdAt.After(info.ModTime()) {
+		f.createdAt = createdAt
+	}
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) shouldRotate(size int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.maxSize > 0 && f.size+int64(size) > f.opts.maxSize {
+		return true
+	}
+	return f.opts.maxAge > 0 && time.Since(f.createdAt) >= f.opts.maxAge
+}
+
+// backupPath returns path of the rotated file of the generation
+func (f *rotatingFile) backupPath(generation int) string {
+	path := generationPath(f.path, generation)
+	if f.opts.compress {
+		path += ".gz"
+	}
+	return path
+}
+
+// backupPaths returns paths of the rotated files of the generation. With compression, the file left uncompressed
+// when its compression failed is returned as well, so it is shifted and removed like the compressed one.
+func (f *rotatingFile) backupPaths(generation int) []string {
+	if f.opts.compress {
+		return []string{f.backupPath(generation), generationPath(f.path, generation)}
+	}
+	return []string{f.backupPath(generation)}
+}
+
+// rotate shifts rotated files by one, removing the ones exceeding the number of backups, moves the current file
+// to the first generation and opens a new one. When the rotation fails, the file is opened again to keep logging.
+func (f *rotatingFile) rotate() (err error) {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	// the rotated file waiting for compression would be shifted by this rotation
+	f.waitCompression()
+	defer func() {
+		if f.file == nil {
+			if openErr := f.open(); err == nil {
+				err = openErr
+			}
+		}
+	}()
+	for generation := f.opts.backups; generation >= 1; generation-- {
+		for i, path := range f.backupPaths(generation) {
+			var err error
+			if generation == f.opts.backups {
+				err = os.Remove(path)
+			} else {
+				err = os.Rename(path, f.backupPaths(generation + 1)[i])
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	switch {
+	case f.opts.backups == 0:
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+	case f.opts.compress:
+		rotated := generationPath(f.path, 1)
+		if err := os.Rename(f.path, rotated); err != nil {
+			return err
+		}
+		f.compressInBackground(rotated, f.backupPath(1))
+	default:
+		if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+// compressInBackground compresses the rotated file without holding up writes to the new file
+func (f *rotatingFile) compressInBackground(path, target string) {
+	done := make(chan struct{})
+	f.compressing = done
+	go func() {
+		defer close(done)
+		if err := compressFile(path, target); err != nil {
+			log.Print(fmt.Errorf("compressing rotated error log file: %w", err))
+		}
+	}()
+}
+
+// waitCompression waits for the compression of the last rotated file to finish
+func (f *rotatingFile) waitCompression() {
+	if f.compressing != nil {
+		<-f.compressing
+		f.compressing = nil
+	}
+}
+
+// compressFile writes the file compressed with gzip to target and removes it
+func compressFile(path, target string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, source)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package errors
+
+import "os"
+
+// reopenSignals are signals reopening log files of file sinks, there are none on this platform
+var reopenSignals []os.Signal
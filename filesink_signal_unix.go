@@ -0,0 +1,11 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package errors
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals are signals reopening log files of file sinks
+var reopenSignals = []os.Signal{syscall.SIGHUP}
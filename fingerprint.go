@@ -0,0 +1,80 @@
+package errors
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Fingerprinter computes the hash of the stack trace which is used as an error code
+type Fingerprinter func(st errors.StackTrace) string
+
+const sizeOfUintPtr = unsafe.Sizeof(uintptr(0))
+
+func uintptrToBytes(u *errors.Frame) []byte {
+	return (*[sizeOfUintPtr]byte)(unsafe.Pointer(u))[:]
+}
+
+// PCFingerprint hashes raw program counters of the stack trace. The hash changes with every build of the program.
+func PCFingerprint(st errors.StackTrace) string {
+	buffer := bytes.Buffer{}
+	for _, f := range st {
+		buffer.Write(uintptrToBytes((&f)))
+	}
+	return fmt.Sprintf("%x", md5.Sum(buffer.Bytes()))
+}
+
+// SymbolFingerprint hashes function names and module relative file paths of the stack trace.
+// The hash is the same across builds and machines, and does not change when lines of the code move.
+func SymbolFingerprint(st errors.StackTrace) string {
+	return symbolFingerprint(st, false)
+}
+
+// SymbolLineFingerprint hashes function names, module relative file paths and line numbers of the stack trace.
+// The hash is the same across builds and machines. It is the default fingerprint.
+func SymbolLineFingerprint(st errors.StackTrace) string {
+	return symbolFingerprint(st, true)
+}
+
+func symbolFingerprint(st errors.StackTrace, withLines bool) string {
+	buffer := bytes.Buffer{}
+	for _, f := range st {
+		pc := uintptr(f) - 1
+		fn := runtime.FuncForPC(pc)
+		if fn == nil {
+			buffer.WriteString("unknown\n")
+			continue
+		}
+		file, line := fn.FileLine(pc)
+		buffer.WriteString(fn.Name())
+		buffer.WriteString(" ")
+		buffer.WriteString(modulePath(fn.Name(), file))
+		if withLines {
+			buffer.WriteString(fmt.Sprintf(":%d", line))
+		}
+		buffer.WriteString("\n")
+	}
+	return fmt.Sprintf("%x", md5.Sum(buffer.Bytes()))
+}
+
+// modulePath returns the file path relative to the module root, built from the package path of the function
+// and the file name. It does not depend on GOPATH, module cache or checkout location.
+func modulePath(funcName, file string) string {
+	return fmt.Sprintf("%s/%s", packagePath(funcName), path.Base(file))
+}
+
+// packagePath returns the import path of the package the function belongs to
+func packagePath(funcName string) string {
+	slash := strings.LastIndex(funcName, "/")
+	dot := strings.Index(funcName[slash+1:], ".")
+	if dot < 0 {
+		return funcName
+	}
+	return funcName[:slash+1+dot]
+}
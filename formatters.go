@@ -2,6 +2,7 @@ package errors
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/pkg/errors"
 )
@@ -22,16 +23,95 @@ func AsJSON(e EnhancedError, verbosityThreshold ...int) json.RawMessage {
 		threshold = verbosityThreshold[0]
 	}
 
-	outputMap := make(map[string]interface{})
-	for _, opt := range e.GetOpts() {
-		if opt.Verbosity() <= threshold {
-			for key, value := range opt.MapFormatter() {
-				outputMap[key] = value
-			}
-		}
-	}
+	outputMap := jsonLayer(e, threshold)
 	outputMap["errorCode"] = e.GetStackTraceHash()
-	outputMap["errorID"] = e.GetErrorID()
+	outputMap["level"] = SeverityName(SeverityOf(e))
 	output, _ := json.Marshal(outputMap)
 	return output
 }
+
+// jsonLayer returns the map representation of the error and all its cause layers
+func jsonLayer(e EnhancedError, threshold int) map[string]interface{} {
+	outputMap := formatOpts(e.GetOpts(), threshold)
+	outputMap["errorID"] = e.GetErrorID()
+	outputMap["content"] = e.Error()
+	if wraps := formatWraps(e, threshold); len(wraps) > 0 {
+		outputMap["wraps"] = wraps
+	}
+	if parentIDs := e.GetParentIDs(); len(parentIDs) > 0 {
+		outputMap["parentIDs"] = parentIDs
+	}
+	if templateID := e.GetTemplateID(); templateID != "" {
+		outputMap["template"] = templateID
+	}
+	if templatePath := e.GetTemplatePath(); len(templatePath) > 1 {
+		outputMap["templatePath"] = templatePath
+	}
+	if cause := e.GetCause(); cause != nil {
+		outputMap["cause"] = jsonLayer(cause, threshold)
+	}
+	return outputMap
+}
+
+// formatOpts merges map representations of error options with verbosity up to the threshold
+func formatOpts(errorOpts map[ErrorOptType]ErrorOpt, verbosityThreshold int) map[string]interface{} {
+	opts := make(map[string]interface{})
+	for _, opt := range errorOpts {
+		if opt.Verbosity() > verbosityThreshold {
+			continue
+		}
+		for key, value := range opt.MapFormatter() {
+			opts[key] = value
+		}
+	}
+	return opts
+}
+
+// formatWraps returns map representations of the wrap frames, starting with the outermost one
+func formatWraps(e EnhancedError, verbosityThreshold int) []map[string]interface{} {
+	wraps := e.GetWraps()
+	output := make([]map[string]interface{}, 0, len(wraps))
+	for _, frame := range wraps {
+		frameMap := map[string]interface{}{
+			"message":  frame.Message,
+			"location": fmt.Sprintf("%s:%d", frame.File, frame.Line),
+		}
+		if opts := formatOpts(frame.Opts, verbosityThreshold); len(opts) > 0 {
+			frameMap["opts"] = opts
+		}
+		output = append(output, frameMap)
+	}
+	return output
+}
+
+// FromJSON restores an enhanced error from the payload produced by AsJSON. Options are not restored,
+// but the content, error ID, parent IDs and template path are, so the error can be matched with Is against named templates
+// and the templates they were derived from.
+func FromJSON(data []byte) (EnhancedError, error) {
+	var payload struct {
+		ErrorID      string   `json:"errorID"`
+		ParentIDs    []string `json:"parentIDs"`
+		TemplateID   string   `json:"template"`
+		TemplatePath []string `json:"templatePath"`
+		Content      string   `json:"content"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, errors.Wrap(err, "decoding enhanced error")
+	}
+	msg := payload.Content
+	if msg == "" {
+		msg = "error"
+	}
+	var templateParents []string
+	if len(payload.TemplatePath) > 1 {
+		templateParents = payload.TemplatePath[:len(payload.TemplatePath)-1]
+	}
+	return &enhancedError{
+		ErrorID:         payload.ErrorID,
+		ParentIDs:       payload.ParentIDs,
+		TemplateID:      payload.TemplateID,
+		TemplateParents: templateParents,
+		error:           fmt.Errorf("%s", msg),
+		Opts:            make(map[ErrorOptType]ErrorOpt),
+	}, nil
+}
@@ -0,0 +1,177 @@
+// Package record parses error records written by the formatters of the errors package back from logs.
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+// Field is a single key value pair of the record
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Record is a single error parsed from the log line
+type Record struct {
+	// Prefix is the text preceding the record, for example the timestamp written by the standard logger
+	Prefix string
+	// Fields keep the order they were written in
+	Fields []Field
+}
+
+// Get returns the value of the field
+func (r Record) Get(key string) (string, bool) {
+	for _, field := range r.Fields {
+		if field.Key == key {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseLogFMT parses the line written by LogFMTFormatter. Text preceding the errorID field is kept as the prefix.
+// It returns false when the line does not contain an error record.
+func ParseLogFMT(line string) (Record, bool) {
+	start := strings.Index(line, "errorID=")
+	for start > 0 && line[start-1] != ' ' {
+		next := strings.Index(line[start+1:], "errorID=")
+		if next < 0 {
+			return Record{}, false
+		}
+		start += next + 1
+	}
+	if start < 0 {
+		return Record{}, false
+	}
+	record := Record{Prefix: line[:start]}
+	decoder := logfmt.NewDecoder(strings.NewReader(line[start:]))
+	for decoder.ScanRecord() {
+		for decoder.ScanKeyval() {
+			record.Fields = append(record.Fields, Field{Key: string(decoder.Key()), Value: string(decoder.Value())})
+		}
+	}
+	if decoder.Err() != nil {
+		return Record{}, false
+	}
+	return record, true
+}
+
+// ParseJSON parses the line containing the error encoded with AsJSON. Text preceding the JSON object is kept
+// as the prefix. Fields are flattened the way LogFMTFormatter writes them: cause layers are prefixed with "cause.",
+// parent IDs are joined with "," and the template path with "/". Other values which are not strings are kept as JSON.
+// It returns false when the line does not contain an error record.
+func ParseJSON(line string) (Record, bool) {
+	start := strings.Index(line, "{")
+	if start < 0 {
+		return Record{}, false
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line[start:])), &object); err != nil {
+		return Record{}, false
+	}
+	if _, ok := object["errorID"]; !ok {
+		return Record{}, false
+	}
+	record := Record{Prefix: line[:start]}
+	record.Fields = flattenJSON(object, "")
+	return record, true
+}
+
+// Parse parses the line written either by LogFMTFormatter or AsJSON
+func Parse(line string) (Record, bool) {
+	if record, ok := ParseJSON(line); ok {
+		return record, true
+	}
+	return ParseLogFMT(line)
+}
+
+// jsonFirstKeys are written before other keys, in the order LogFMTFormatter writes them
+var jsonFirstKeys = []string{"errorID", "errorCode", "level", "parentIDs", "template", "templatePath"}
+
+func flattenJSON(object map[string]json.RawMessage, prefix string) []Field {
+	keys := make([]string, 0, len(object))
+	for _, key := range jsonFirstKeys {
+		if _, ok := object[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	var rest []string
+	for key := range object {
+		if key != "cause" && !isFirstKey(key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	var fields []Field
+	for _, key := range keys {
+		value := object[key]
+		var text string
+		var list []string
+		switch {
+		case json.Unmarshal(value, &text) == nil:
+		case key == "parentIDs" && json.Unmarshal(value, &list) == nil:
+			text = strings.Join(list, ",")
+		case key == "templatePath" && json.Unmarshal(value, &list) == nil:
+			text = strings.Join(list, "/")
+		default:
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, value); err != nil {
+				continue
+			}
+			text = compact.String()
+		}
+		fields = append(fields, Field{Key: prefix + key, Value: text})
+	}
+	var cause map[string]json.RawMessage
+	if raw, ok := object["cause"]; ok && json.Unmarshal(raw, &cause) == nil {
+		fields = append(fields, flattenJSON(cause, prefix+"cause.")...)
+	}
+	return fields
+}
+
+func isFirstKey(key string) bool {
+	for _, first := range jsonFirstKeys {
+		if key == first {
+			return true
+		}
+	}
+	return false
+}
+
+// logTimeLayouts are layouts of timestamps written by the standard logger
+var logTimeLayouts = []string{"2006/01/02 15:04:05.000000", "2006/01/02 15:04:05"}
+
+// Time returns the time the record was logged at. It is read from the "time" or "ts" field, or from the timestamp
+// written by the standard logger at the start of the prefix.
+func (r Record) Time() (time.Time, bool) {
+	for _, key := range []string{"time", "ts"} {
+		value, ok := r.Get(key)
+		if !ok {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, true
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Unix(0, int64(seconds*float64(time.Second))), true
+		}
+	}
+	for _, layout := range logTimeLayouts {
+		if len(r.Prefix) < len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, r.Prefix[:len(layout)], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package errors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+type journaldOpts struct {
+	socket     string
+	identifier string
+	verbosity  int
+}
+
+type JournaldOption func(*journaldOpts)
+
+// WithJournaldSocket sets the path of the journald socket. The default is /run/systemd/journal/socket.
+func WithJournaldSocket(path string) JournaldOption {
+	return func(o *journaldOpts) {
+		o.socket = path
+	}
+}
+
+// WithJournaldIdentifier sets the SYSLOG_IDENTIFIER field. The default is the name of the executable.
+func WithJournaldIdentifier(identifier string) JournaldOption {
+	return func(o *journaldOpts) {
+		o.identifier = identifier
+	}
+}
+
+// WithJournaldVerbosity sets the verbosity threshold of options written as journal fields
+func WithJournaldVerbosity(verbosity int) JournaldOption {
+	return func(o *journaldOpts) {
+		o.verbosity = verbosity
+	}
+}
+
+// JournaldSink writes errors to systemd-journald with its native protocol. The formatted record is the MESSAGE field,
+// the severity of the error is mapped to PRIORITY and the error ID, stack trace hash, template and options are
+// written as fields with upper snake case names, like ERROR_ID, ERROR_CODE or STATUS_CODE.
+type JournaldSink struct {
+	opts *journaldOpts
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// NewJournaldSink opens the datagram socket sending messages to journald
+func NewJournaldSink(opts ...JournaldOption) (*JournaldSink, error) {
+	options := &journaldOpts{socket: defaultJournaldSocket}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.identifier == "" {
+		if executable, err := os.Executable(); err == nil {
+			options.identifier = executable[strings.LastIndex(executable, "/")+1:]
+		}
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldSink{
+		opts: options,
+		conn: conn,
+		addr: &net.UnixAddr{Name: options.socket, Net: "unixgram"},
+	}, nil
+}
+
+func (s *JournaldSink) WriteError(e EnhancedError, record []byte) error {
+	_, _, err := s.conn.WriteMsgUnix(s.message(e, record), nil, s.addr)
+	return err
+}
+
+func (s *JournaldSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *JournaldSink) message(e EnhancedError, record []byte) []byte {
+	severity := LogError
+	if e != nil {
+		severity = SeverityOf(e)
+	}
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", string(bytes.TrimRight(record, "\n")))
+	writeJournaldField(&buf, "PRIORITY", strconv.Itoa(syslogSeverity(severity)))
+	if s.opts.identifier != "" {
+		writeJournaldField(&buf, "SYSLOG_IDENTIFIER", s.opts.identifier)
+	}
+	for _, field := range sinkFields(e, s.opts.verbosity) {
+		name := journaldFieldName(field.key)
+		if name == "" || name == "MESSAGE" || name == "PRIORITY" || name == "SYSLOG_IDENTIFIER" {
+			continue
+		}
+		writeJournaldField(&buf, name, field.value)
+	}
+	return buf.Bytes()
+}
+
+// writeJournaldField writes the field as NAME=value line. Values with new lines are written as the name line
+// followed by the little endian 64-bit size of the value, the value and a new line.
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// journaldFieldName converts the key to the journal field name, for example statusCode to STATUS_CODE.
+// Field names consist of upper case letters, digits and underscores and start with a letter.
+func journaldFieldName(key string) string {
+	var name strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		case name.Len() > 0:
+			name.WriteByte('_')
+		}
+	}
+	field := strings.TrimLeft(strings.TrimRight(name.String(), "_"), "0123456789_")
+	if len(field) > 64 {
+		field = field[:64]
+	}
+	return field
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package errors_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/opts"
+	"github.com/stretchr/testify/assert"
+)
+
+// parseJournaldFields decodes the native journald protocol message
+func parseJournaldFields(t *testing.T, message []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(message) > 0 {
+		end := bytes.IndexByte(message, '\n')
+		if !assert.GreaterOrEqual(t, end, 0) {
+			break
+		}
+		line := message[:end]
+		message = message[end+1:]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			continue
+		}
+		size := binary.LittleEndian.Uint64(message)
+		fields[string(line)] = string(message[8 : 8+size])
+		message = message[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournaldSink(t *testing.T) {
+	dir, err := os.MkdirTemp("", "journald")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "socket")
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer socket.Close()
+	sink, err := errors.NewJournaldSink(errors.WithJournaldSocket(path), errors.WithJournaldIdentifier("app"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Close()
+
+	err1 := errTestSyslog.From(fmt.Errorf("not found")).With(opts.StatusCode(404), opts.RequestID("req-1"))
+	errors.CustomLogger(errors.WithSink(sink))(err1)
+	buf := make([]byte, 64*1024)
+	n, err := socket.Read(buf)
+	assert.NoError(t, err)
+	fields := parseJournaldFields(t, buf[:n])
+	assert.Contains(t, fields["MESSAGE"], "\tCONTENT: not found \n")
+	assert.Equal(t, map[string]string{
+		"MESSAGE":           fields["MESSAGE"],
+		"PRIORITY":          "6",
+		"SYSLOG_IDENTIFIER": "app",
+		"ERROR_ID":          err1.GetErrorID(),
+		"ERROR_CODE":        err1.GetStackTraceHash(),
+		"TEMPLATE":          "test.syslog",
+		"STATUS_CODE":       "404",
+		"REQUEST_ID":        "req-1",
+	}, fields)
+}
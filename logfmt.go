@@ -3,6 +3,7 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 
@@ -12,60 +13,103 @@ import (
 
 func LogFMTFormatter(e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) string {
 	var sb strings.Builder
-	encoder := logfmt.NewEncoder(&sb)
-	encoder.EncodeKeyval("errorID", e.GetErrorID())
-	encoder.EncodeKeyval("errorCode", e.GetStackTraceHash())
-	var wrapper Wrapper
-	value, ok := e.GetOpts()[wrapper.Type()]
-	if ok {
-		wrapper = value.(Wrapper)
-	}
-	if wrapper != "" {
-		wrapper = Wrapper(fmt.Sprintf("%s: ", wrapper))
-	}
-	encoder.EncodeKeyval("content", fmt.Sprintf("%s%s", wrapper, e.GetInternalError()))
-	opts := make(map[string]interface{})
-	for _, opt := range e.GetOpts() {
-		for key, value := range opt.MapFormatter() {
-			if opt.Verbosity() > verbosityThreshold {
-				continue
-			}
-			opts[key] = value
-		}
+	if err := WriteLogFMT(&sb, e, verbosityThreshold, stackTraceFormatter); err != nil {
+		return "Error in logfmt formatter"
 	}
-	for opt, value := range opts {
-		if reflect.ValueOf(value).Kind() == reflect.Struct {
-			valueBytes, err := json.Marshal(value)
-			if err != nil {
-				panic(err)
-			}
-			value = string(valueBytes)
-		}
-		encoder.EncodeKeyval(opt, value)
+	return sb.String()
+}
+
+// WriteLogFMT writes the error formatted like LogFMTFormatter to w
+func WriteLogFMT(w io.Writer, e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) error {
+	writer := &logFMTWriter{w: w}
+	encoder := &logFMTEncoder{Encoder: logfmt.NewEncoder(writer)}
+	encoder.encode("errorID", e.GetErrorID())
+	encoder.encode("errorCode", e.GetStackTraceHash())
+	encoder.encode("level", SeverityName(SeverityOf(e)))
+	encodeLogFMTLayer(encoder, e, "", verbosityThreshold)
+	prefix := ""
+	for cause := e.GetCause(); cause != nil; cause = cause.GetCause() {
+		prefix += "cause."
+		encoder.encode(prefix+"errorID", cause.GetErrorID())
+		encodeLogFMTLayer(encoder, cause, prefix, verbosityThreshold)
 	}
 	stackTrace := e.GetStackTrace()
 	stackTraceMsg := stackTraceFormatter(stackTrace)
 	stackTraceMsg = strings.ReplaceAll(stackTraceMsg, "\n", "$")
 
 	if stackTraceMsg != "" {
-		encoder.EncodeKeyval("stackTrace", stackTraceMsg)
+		encoder.encode("stackTrace", stackTraceMsg)
+	}
+	if encoder.err != nil {
+		return encoder.err
 	}
-
 	if err := encoder.EndRecord(); err != nil {
-		return "Error in logfmt formatter"
+		return err
 	}
-	return sb.String()
+	return writer.err
 }
 
-func JSONStackTraceFormatter(st pkgerrors.StackTrace) string {
-	type stackTraceLine struct {
-		SourceFile   string `json:"file"`
-		SourceLine   string `json:"line"`
-		FunctionName string `json:"func"`
+// logFMTWriter keeps the first write error. Encoding errors of single values are not reported, so values which
+// can not be encoded are skipped, but failed writes fail the whole record.
+type logFMTWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *logFMTWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
 	}
-	stackTrace := make([]stackTraceLine, 0, len(st))
+	n, err := w.w.Write(p)
+	w.err = err
+	return n, err
+}
+
+// logFMTEncoder keeps the first error of values marshaled to JSON before encoding
+type logFMTEncoder struct {
+	*logfmt.Encoder
+	err error
+}
+
+func (e *logFMTEncoder) encode(key string, value interface{}) {
+	e.EncodeKeyval(key, value)
+}
+
+// encodeLogFMTLayer encodes the template, content and options of a single error layer with keys prefixed by prefix
+func encodeLogFMTLayer(encoder *logFMTEncoder, e EnhancedError, prefix string, verbosityThreshold int) {
+	if parentIDs := e.GetParentIDs(); len(parentIDs) > 0 {
+		encoder.encode(prefix+"parentIDs", strings.Join(parentIDs, ","))
+	}
+	if templateID := e.GetTemplateID(); templateID != "" {
+		encoder.encode(prefix+"template", templateID)
+	}
+	if templatePath := e.GetTemplatePath(); len(templatePath) > 1 {
+		encoder.encode(prefix+"templatePath", strings.Join(templatePath, "/"))
+	}
+	encoder.encode(prefix+"content", e.Error())
+	if wraps := formatWraps(e, verbosityThreshold); len(wraps) > 0 {
+		wrapsBytes, err := json.Marshal(wraps)
+		if err != nil && encoder.err == nil {
+			encoder.err = err
+		}
+		encoder.encode(prefix+"wraps", string(wrapsBytes))
+	}
+	for opt, value := range formatOpts(e.GetOpts(), verbosityThreshold) {
+		if reflect.ValueOf(value).Kind() == reflect.Struct {
+			valueBytes, err := json.Marshal(value)
+			if err != nil && encoder.err == nil {
+				encoder.err = err
+			}
+			value = string(valueBytes)
+		}
+		encoder.encode(prefix+opt, value)
+	}
+}
+
+func JSONStackTraceFormatter(st pkgerrors.StackTrace) string {
+	stackTrace := make([]StackFrame, 0, len(st))
 	for _, f := range st {
-		stackTrace = append(stackTrace, stackTraceLine{
+		stackTrace = append(stackTrace, StackFrame{
 			SourceFile:   fmt.Sprintf("%s", f),
 			SourceLine:   fmt.Sprintf("%d", f),
 			FunctionName: fmt.Sprintf("%n", f),
@@ -1,10 +1,14 @@
 package errors
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"sync"
 )
 
+// Severities of errors, see SeverityOf
 const (
 	LogDebug = iota + 10
 	LogInfo
@@ -14,9 +18,11 @@ const (
 
 type loggerOpts struct {
 	verbosity           int
+	minSeverity         int
 	saveStack           bool
 	stackTraceFormatter StackTraceFormatter
-	errorFormatter      ErrorFormatter
+	streamFormatter     StreamFormatter
+	sink                Sink
 }
 
 type LoggerOption func(*loggerOpts)
@@ -35,31 +41,74 @@ func WithStackTraceFormatter(formatter StackTraceFormatter) LoggerOption {
 
 func WithErrorFormatter(formatter ErrorFormatter) LoggerOption {
 	return func(o *loggerOpts) {
-		o.errorFormatter = formatter
+		o.streamFormatter = streamErrorFormatter(formatter)
 	}
 }
 
+// WithStreamFormatter sets the formatter writing the error straight to the record buffer, for example WriteLogFMT.
+// It replaces the formatter set with WithErrorFormatter.
+func WithStreamFormatter(formatter StreamFormatter) LoggerOption {
+	return func(o *loggerOpts) {
+		o.streamFormatter = formatter
+	}
+}
+
+// WithSink sets the sink receiving formatted errors. By default errors are printed with the standard logger.
+func WithSink(sink Sink) LoggerOption {
+	return func(o *loggerOpts) {
+		o.sink = sink
+	}
+}
+
+// WithOutput writes formatted errors to w, without the prefix and flags of the standard logger
+func WithOutput(w io.Writer) LoggerOption {
+	return WithSink(NewWriterSink(w))
+}
+
+// WithStdLogger prints formatted errors with the logger, using its prefix and flags
+func WithStdLogger(logger *log.Logger) LoggerOption {
+	return WithSink(NewStdLoggerSink(logger))
+}
+
 func WithSaveStack(saveStack bool) LoggerOption {
 	return func(o *loggerOpts) {
 		o.saveStack = saveStack
 	}
 }
 
+// WithMinSeverity skips errors with severity lower than the given one, for example LogWarning
+func WithMinSeverity(severity int) LoggerOption {
+	return func(o *loggerOpts) {
+		o.minSeverity = severity
+	}
+}
+
 func CustomLogger(opts ...LoggerOption) LoggerFunc {
 	options := &loggerOpts{
 		verbosity:           0,
 		stackTraceFormatter: MultilineStackTraceFormatter,
-		errorFormatter:      MultilineFormatter,
+		streamFormatter:     WriteMultiline,
+		sink:                NewStdLoggerSink(nil),
 		saveStack:           false,
 	}
 	for _, opt := range opts {
 		opt(options)
 	}
 	return func(e EnhancedError) {
-		log.Print(options.errorFormatter(e, options.verbosity, options.stackTraceFormatter))
+		if SeverityOf(e) < options.minSeverity {
+			return
+		}
+		buffer := recordBuffers.Get().(*bytes.Buffer)
+		buffer.Reset()
+		if err := options.streamFormatter(buffer, e, options.verbosity, options.stackTraceFormatter); err != nil {
+			ReportSinkError(e, fmt.Errorf("formatting error: %w", err))
+		} else if err := options.sink.WriteError(e, buffer.Bytes()); err != nil {
+			ReportSinkError(e, fmt.Errorf("writing error: %w", err))
+		}
+		recordBuffers.Put(buffer)
 		if options.saveStack {
-			if err := Manager().SaveStack(e); err != nil {
-				log.Print(fmt.Errorf("error saving stack: %w", err))
+			if err := e.GetManager().SaveStack(e); err != nil {
+				ReportSinkError(e, fmt.Errorf("saving stack: %w", err))
 			}
 		}
 	}
@@ -68,3 +117,59 @@ func CustomLogger(opts ...LoggerOption) LoggerFunc {
 func DefaultLogger() LoggerFunc {
 	return CustomLogger()
 }
+
+// MissingLoggerPolicy describes how logging with a logger which is not registered is handled
+type MissingLoggerPolicy int
+
+const (
+	// MissingLoggerPanic panics in Log. It is the default policy.
+	MissingLoggerPanic MissingLoggerPolicy = iota
+	// MissingLoggerFallback logs the error with the default logger instead
+	MissingLoggerFallback
+	// MissingLoggerDrop does not log the error and counts it in DroppedLogs
+	MissingLoggerDrop
+)
+
+// ErrLoggerNotFound is reported when the error is logged with a logger which is not registered
+var ErrLoggerNotFound = fmt.Errorf("logger not found")
+
+// SinkErrorHook is called when the logger fails, panics or is missing. Logger is empty when the failure was reported
+// for an error which was not logged through the manager.
+type SinkErrorHook func(err EnhancedError, logger LogName, sinkErr error)
+
+// logCall collects failures of a single logger call. Asynchronous loggers may report failures after the call returned.
+type logCall struct {
+	manager *errorsManager
+	logger  LogName
+	mu      sync.Mutex
+	errs    []error
+}
+
+func (c *logCall) report(err EnhancedError, sinkErr error) {
+	c.mu.Lock()
+	c.errs = append(c.errs, sinkErr)
+	c.mu.Unlock()
+	c.manager.sinkError(err, c.logger, sinkErr)
+}
+
+// firstError returns the first failure reported during the call
+func (c *logCall) firstError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs[0]
+}
+
+// ReportSinkError reports the failure of the logger logging the error, for example when the log could not be written.
+// The failure is passed to the OnSinkError hook of the manager and returned from LogE.
+func ReportSinkError(e EnhancedError, sinkErr error) {
+	if enErr, ok := e.(*enhancedError); ok && enErr.call != nil {
+		enErr.call.report(e, sinkErr)
+		return
+	}
+	if m, ok := e.GetManager().(*errorsManager); ok {
+		m.sinkError(e, "", sinkErr)
+	}
+}
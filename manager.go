@@ -1,109 +1,330 @@
 package errors
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"os"
-	"strings"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type ErrorsManager interface {
-	// SaveStack saves the stack trace of the error in the stack trace file. Format is optional and can be used to format the stack trace
+	// SaveStack saves the stack trace of the error in the stack store. Format is optional and can be used to format the stack trace
 	SaveStack(err EnhancedError, format ...StackTraceFormatter) error
 	// RegisterLogger registers a logger for a specific log name
 	RegisterLogger(name LogName, logger LoggerFunc)
 	// SetDefaultLogger sets the default logger
 	SetDefaultLogger(logger LoggerFunc)
-	// Setup creates a new stack trace file and reads the existing one
-	Setup(stackTracePath string) error
+	// Setup opens the stack store at the given path and reads the existing one. By default the text stack file is used
+	Setup(stackTracePath string, opts ...SetupOption) error
+	// SetupStore sets the stack store used for saving stack traces
+	SetupStore(store StackStore) error
+	// StackStore returns the stack store set up for the manager or nil
+	StackStore() StackStore
+	// SetFingerprinter sets the function computing stack trace hashes used as error codes
+	SetFingerprinter(fingerprinter Fingerprinter)
+	// Fingerprint returns the hash of the stack trace used as an error code
+	Fingerprint(st errors.StackTrace) string
+	// Log logs the error using the default logger or the loggers specified in the function. Missing loggers are handled
+	// according to the missing logger policy, by default the program panics.
+	Log(err EnhancedError, loggers ...LogName)
+	// LogE logs the error like Log, but never panics. It returns the first missing logger or logger failure.
+	LogE(err EnhancedError, loggers ...LogName) error
+	// SetMissingLoggerPolicy sets how logging with a logger which is not registered is handled
+	SetMissingLoggerPolicy(policy MissingLoggerPolicy)
+	// DroppedLogs returns the number of logger calls dropped because the logger was not registered
+	DroppedLogs() int64
+	// Route sends errors matching the predicate to the loggers when they are logged without logger names.
+	// Such errors are logged with the default logger and the loggers of all matching routes.
+	Route(predicate Predicate, loggers ...LogName)
+	// OnSinkError sets the hook called when a logger fails, panics or is missing. By default failures are printed with log.Print.
+	OnSinkError(hook SinkErrorHook)
+	// Reset restores the default configuration and closes the stack store. It is meant for tests.
+	// Names registered with Template are global and are kept, registering them again still panics.
+	Reset() error
+	// RegisterFlusher registers the flusher flushed by Flush and Close, for example an asynchronous logger
+	RegisterFlusher(flusher Flusher)
+	// Flush flushes registered flushers and the stack store, writing buffered data to disk
+	Flush(ctx context.Context) error
+	// Close flushes and closes registered flushers and the stack store. Stack traces can not be saved after Close.
+	Close(ctx context.Context) error
+}
+
+// Flusher is implemented by loggers and stack stores buffering data. Flushers implementing io.Closer are closed by Close,
+// flushers implementing Shutdowner are shut down with the context of Close instead.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Shutdowner is implemented by flushers whose Close may wait for an external service, like WebhookSink.
+// Shutdown stops the flusher like Close, giving up on buffered data when ctx is done.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// FlusherFunc is a function implementing Flusher
+type FlusherFunc func(ctx context.Context) error
+
+func (f FlusherFunc) Flush(ctx context.Context) error {
+	return f(ctx)
 }
 
 type errorsManager struct {
-	stackTracePath string
-	stackFile      *os.File
-	stackWriter    *Writer
+	// dropped is accessed atomically, it is the first field to be 64-bit aligned on 32-bit platforms
+	dropped int64
+
+	mu sync.RWMutex
 
-	stacks map[string]bool
+	stackStore StackStore
 
 	loggers map[LogName]LoggerFunc
+
+	fingerprinter Fingerprinter
+
+	rawStacks bool
+
+	flushers []Flusher
+
+	missingLoggerPolicy MissingLoggerPolicy
+	sinkErrorHook       SinkErrorHook
+
+	routes []route
 }
 
-var errManager errorsManager
+// errManager is the default manager used by errors which are not bound to other manager
+var errManager = newErrorsManager()
+
+func newErrorsManager() *errorsManager {
+	m := &errorsManager{}
+	m.reset()
+	return m
+}
 
-func init() {
-	errManager.stacks = make(map[string]bool)
-	errManager.loggers = map[LogName]LoggerFunc{
+// reset restores the default configuration. The caller must hold the lock when the manager is shared.
+func (m *errorsManager) reset() {
+	m.stackStore = nil
+	m.loggers = map[LogName]LoggerFunc{
 		DefaultLog: DefaultLogger(),
 	}
+	m.fingerprinter = SymbolLineFingerprint
+	m.rawStacks = false
+	m.flushers = nil
+	m.missingLoggerPolicy = MissingLoggerPanic
+	m.sinkErrorHook = nil
+	m.routes = nil
+	atomic.StoreInt64(&m.dropped, 0)
+}
+
+type ManagerOption func(*errorsManager)
+
+// WithLogger registers the logger under the name
+func WithLogger(name LogName, logger LoggerFunc) ManagerOption {
+	return func(m *errorsManager) {
+		m.loggers[name] = logger
+	}
+}
+
+// WithDefaultLogger sets the default logger
+func WithDefaultLogger(logger LoggerFunc) ManagerOption {
+	return WithLogger(DefaultLog, logger)
+}
+
+// WithFingerprinter sets the function computing stack trace hashes used as error codes
+func WithFingerprinter(fingerprinter Fingerprinter) ManagerOption {
+	return func(m *errorsManager) {
+		m.fingerprinter = fingerprinter
+	}
+}
+
+// WithMissingLoggerPolicy sets how logging with a logger which is not registered is handled
+func WithMissingLoggerPolicy(policy MissingLoggerPolicy) ManagerOption {
+	return func(m *errorsManager) {
+		m.missingLoggerPolicy = policy
+	}
+}
+
+// WithSinkErrorHook sets the hook called when a logger fails, panics or is missing
+func WithSinkErrorHook(hook SinkErrorHook) ManagerOption {
+	return func(m *errorsManager) {
+		m.sinkErrorHook = hook
+	}
+}
+
+// WithRoute sends errors matching the predicate to the loggers when they are logged without logger names
+func WithRoute(predicate Predicate, loggers ...LogName) ManagerOption {
+	return func(m *errorsManager) {
+		m.routes = append(m.routes, route{predicate: predicate, loggers: loggers})
+	}
+}
+
+// WithStore sets the stack store used for saving stack traces
+func WithStore(store StackStore) ManagerOption {
+	return func(m *errorsManager) {
+		m.stackStore = store
+	}
+}
+
+// NewManager returns a manager independent of the default one returned by Manager. Errors use it when they are bound
+// to it with Bind, or when they are created from a template bound to it.
+func NewManager(opts ...ManagerOption) ErrorsManager {
+	m := newErrorsManager()
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+type managerContextKey struct{}
+
+// ContextWithManager returns a copy of the context carrying the manager
+func ContextWithManager(ctx context.Context, m ErrorsManager) context.Context {
+	return context.WithValue(ctx, managerContextKey{}, m)
+}
+
+// ManagerFromContext returns the manager carried by the context, or the default manager
+func ManagerFromContext(ctx context.Context) ErrorsManager {
+	if m, ok := ctx.Value(managerContextKey{}).(ErrorsManager); ok {
+		return m
+	}
+	return Manager()
 }
 
 func (e *errorsManager) SaveStack(err EnhancedError, format ...StackTraceFormatter) error {
-	if e.stackTracePath == "" {
+	e.mu.RLock()
+	store, rawStacks := e.stackStore, e.rawStacks
+	e.mu.RUnlock()
+	if store == nil {
 		return fmt.Errorf("stack trace path not set")
 	}
 	formatter := MultilineStackTraceFormatter
 	if len(format) > 0 {
 		formatter = format[0]
 	}
-	stackTraceHash := err.GetStackTraceHash()
-	if _, ok := e.stacks[stackTraceHash]; !ok {
-		e.stacks[stackTraceHash] = true
-		message := fmt.Sprintf(">>> %s\n%s\n", stackTraceHash, formatter(err.GetStackTrace()))
-		if _, err := e.stackWriter.WriteString(message); err != nil {
-			return err
+	stackTrace := err.GetStackTrace()
+	now := time.Now().UTC()
+	entry := StackEntry{
+		Hash:      err.GetStackTraceHash(),
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     1,
+		Message:   err.Error(),
+		Template:  err.GetTemplateID(),
+		Revision:  revision(),
+	}
+	if rawStacks {
+		entry.PCs = rawPCs(stackTrace)
+		entry.BuildID = buildID()
+	} else {
+		entry.resolve = func(entry *StackEntry) {
+			entry.Frames = stackFrames(stackTrace)
+			entry.Trace = formatter(stackTrace)
+		}
+		if lazy, ok := store.(LazyStackStore); !ok || !lazy.ResolvesFramesLazily() {
+			entry.ResolveFrames()
 		}
 	}
-	return nil
+	return store.Put(entry)
 }
 
+// Manager returns the default manager
 func Manager() ErrorsManager {
-	return &errManager
+	return errManager
+}
+
+type setupOpts struct {
+	format    StackFormat
+	rotation  rotationOpts
+	rawStacks bool
+}
+
+type SetupOption func(*setupOpts)
+
+func newSetupOpts(opts []SetupOption) *setupOpts {
+	options := &setupOpts{
+		format: StackFormatText,
+		rotation: rotationOpts{
+			retention: 3,
+		},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithStackFormat sets the format of the stack store opened by Setup
+func WithStackFormat(format StackFormat) SetupOption {
+	return func(o *setupOpts) {
+		o.format = format
+	}
+}
+
+// WithStackRotation rotates the stack file when it grows over maxSize bytes or when it is older than maxAge.
+// Zero value disables the limit. Rotation is supported by the text and the JSON Lines stack files.
+// With maxAge set, the file starts with a line recording when it was created, so its age survives restarts.
+func WithStackRotation(maxSize int64, maxAge time.Duration) SetupOption {
+	return func(o *setupOpts) {
+		o.rotation.maxSize = maxSize
+		o.rotation.maxAge = maxAge
+	}
 }
 
-func (m *errorsManager) Setup(stackTracePath string) error {
-	if m.stackTracePath != "" {
+// WithStackRetention sets the number of rotated stack file generations kept next to the current one. The default is 3.
+func WithStackRetention(generations int) SetupOption {
+	return func(o *setupOpts) {
+		o.rotation.retention = generations
+	}
+}
+
+// WithRawStacks saves raw program counters and the Go build ID instead of resolved stack frames, which makes saving
+// stack traces cheaper. Saved stack traces are resolved later with Symbolizer or "errstack symbolize" against
+// the binary. Raw stack traces are supported by the JSON Lines and the directory stack stores. Error codes are still
+// computed by the fingerprinter of the manager, RawPCFingerprint can be set explicitly at the cost of per build codes.
+func WithRawStacks() SetupOption {
+	return func(o *setupOpts) {
+		o.rawStacks = true
+	}
+}
+
+func (m *errorsManager) Setup(stackTracePath string, opts ...SetupOption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stackStore != nil {
 		return fmt.Errorf("duplicated error initialization")
 	}
-	err := func() error {
-		if _, err := os.Stat(stackTracePath); os.IsNotExist(err) {
-			return nil
-		}
-		file, err := os.Open(stackTracePath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			if text := scanner.Text(); strings.HasPrefix(text, ">>>") {
-				parts := strings.Split(text, " ")
-				errManager.stacks[parts[1]] = true
-			}
-		}
-		if err := scanner.Err(); err != nil {
-			return err
-		}
-		return nil
-	}()
-	if err != nil {
-		return errors.Wrap(err, "reading error stack file")
+	options := newSetupOpts(opts)
+	if options.rawStacks && options.format == StackFormatText {
+		return fmt.Errorf("raw stacks are not supported by the %s stack format", options.format)
 	}
-	stFile, err := os.OpenFile(stackTracePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	store, err := OpenStackStore(stackTracePath, options.format, opts...)
 	if err != nil {
 		return errors.Wrap(err, "Log Setup")
 	}
+	m.rawStacks = options.rawStacks
+	m.stackStore = store
+	return nil
+}
 
-	m.stackTracePath = stackTracePath
-	m.stackFile = stFile
-	m.stackWriter = NewWriter(stFile)
-
+func (m *errorsManager) SetupStore(store StackStore) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stackStore != nil {
+		return fmt.Errorf("duplicated error initialization")
+	}
+	m.stackStore = store
 	return nil
 }
 
+func (m *errorsManager) StackStore() StackStore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stackStore
+}
+
 type LoggerFunc func(err EnhancedError)
 
 type LogName string
@@ -111,11 +332,231 @@ type LogName string
 const DefaultLog LogName = "default"
 
 func (m *errorsManager) RegisterLogger(name LogName, logger LoggerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.loggers[name] = logger
 }
 
 func (m *errorsManager) SetDefaultLogger(logger LoggerFunc) {
-	m.loggers[DefaultLog] = logger
+	m.RegisterLogger(DefaultLog, logger)
+}
+
+func (m *errorsManager) SetFingerprinter(fingerprinter Fingerprinter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fingerprinter = fingerprinter
+}
+
+func (m *errorsManager) Fingerprint(st errors.StackTrace) string {
+	m.mu.RLock()
+	fingerprinter := m.fingerprinter
+	m.mu.RUnlock()
+	return fingerprinter(st)
+}
+
+func (m *errorsManager) Log(err EnhancedError, loggers ...LogName) {
+	m.log(err, true, loggers)
+}
+
+func (m *errorsManager) LogE(err EnhancedError, loggers ...LogName) error {
+	return m.log(err, false, loggers)
+}
+
+// log calls the loggers and returns the first failure. With panicOnMissing missing loggers panic according to the policy.
+func (m *errorsManager) log(err EnhancedError, panicOnMissing bool, loggers []LogName) error {
+	if len(loggers) == 0 {
+		loggers = m.routedLoggers(err)
+	}
+	type namedLogger struct {
+		name   LogName
+		logger LoggerFunc
+	}
+	// loggers are called without holding the lock, so they can use the manager
+	calls := make([]namedLogger, 0, len(loggers))
+	var missing []LogName
+	m.mu.RLock()
+	policy := m.missingLoggerPolicy
+	fallback := false
+	for _, name := range loggers {
+		logF, ok := m.loggers[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		calls = append(calls, namedLogger{name: name, logger: logF})
+		fallback = fallback || name == DefaultLog
+	}
+	if len(missing) > 0 && policy == MissingLoggerFallback && !fallback {
+		calls = append(calls, namedLogger{name: DefaultLog, logger: m.loggers[DefaultLog]})
+	}
+	m.mu.RUnlock()
+
+	var firstErr error
+	for _, name := range missing {
+		if policy == MissingLoggerPanic && panicOnMissing {
+			panic(fmt.Sprintf("Logger %s not found", name))
+		}
+		if policy == MissingLoggerDrop {
+			atomic.AddInt64(&m.dropped, 1)
+		}
+		missingErr := fmt.Errorf("%w: %s", ErrLoggerNotFound, name)
+		m.sinkError(err, name, missingErr)
+		if firstErr == nil {
+			firstErr = missingErr
+		}
+	}
+	for _, call := range calls {
+		if call.logger == nil {
+			continue
+		}
+		if callErr := m.callLogger(err, call.name, call.logger); callErr != nil && firstErr == nil {
+			firstErr = callErr
+		}
+	}
+	return firstErr
+}
+
+// routedLoggers returns the default logger followed by loggers of the routes matching the error
+func (m *errorsManager) routedLoggers(err EnhancedError) []LogName {
+	m.mu.RLock()
+	routes := m.routes
+	m.mu.RUnlock()
+	loggers := []LogName{DefaultLog}
+	seen := map[LogName]bool{DefaultLog: true}
+	for _, r := range routes {
+		if !r.predicate(err) {
+			continue
+		}
+		for _, logger := range r.loggers {
+			if !seen[logger] {
+				seen[logger] = true
+				loggers = append(loggers, logger)
+			}
+		}
+	}
+	return loggers
+}
+
+func (m *errorsManager) Route(predicate Predicate, loggers ...LogName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// routes are copied on write, so they can be evaluated without holding the lock
+	routes := make([]route, len(m.routes), len(m.routes)+1)
+	copy(routes, m.routes)
+	m.routes = append(routes, route{predicate: predicate, loggers: loggers})
+}
+
+// callLogger calls the logger with a copy of the error collecting failures reported with ReportSinkError.
+// Panics of the logger are recovered and reported as failures.
+func (m *errorsManager) callLogger(err EnhancedError, name LogName, logger LoggerFunc) (callErr error) {
+	call := &logCall{manager: m, logger: name}
+	if enErr, ok := err.(*enhancedError); ok {
+		withCall := *enErr
+		withCall.call = call
+		err = &withCall
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			call.report(err, fmt.Errorf("logger %s panicked: %v", name, r))
+		}
+		callErr = call.firstError()
+	}()
+	logger(err)
+	return nil
+}
+
+// sinkError passes the failure of the logger to the hook
+func (m *errorsManager) sinkError(err EnhancedError, logger LogName, sinkErr error) {
+	m.mu.RLock()
+	hook := m.sinkErrorHook
+	m.mu.RUnlock()
+	if hook == nil {
+		log.Print(fmt.Errorf("error logging with %s logger: %w", logger, sinkErr))
+		return
+	}
+	hook(err, logger, sinkErr)
+}
+
+func (m *errorsManager) SetMissingLoggerPolicy(policy MissingLoggerPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.missingLoggerPolicy = policy
+}
+
+func (m *errorsManager) DroppedLogs() int64 {
+	return atomic.LoadInt64(&m.dropped)
+}
+
+func (m *errorsManager) OnSinkError(hook SinkErrorHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinkErrorHook = hook
+}
+
+func (m *errorsManager) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	if m.stackStore != nil {
+		err = m.stackStore.Close()
+	}
+	m.reset()
+	return err
+}
+
+func (m *errorsManager) RegisterFlusher(flusher Flusher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushers = append(m.flushers, flusher)
+}
+
+// flushTargets returns registered flushers followed by the stack store when it buffers data
+func (m *errorsManager) flushTargets() []Flusher {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	flushers := append([]Flusher{}, m.flushers...)
+	if flusher, ok := m.stackStore.(Flusher); ok {
+		flushers = append(flushers, flusher)
+	}
+	return flushers
+}
+
+func (m *errorsManager) Flush(ctx context.Context) error {
+	var flushErr error
+	for _, flusher := range m.flushTargets() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := flusher.Flush(ctx); err != nil && flushErr == nil {
+			flushErr = err
+		}
+	}
+	return flushErr
+}
+
+func (m *errorsManager) Close(ctx context.Context) error {
+	closeErr := m.Flush(ctx)
+	m.mu.Lock()
+	flushers, store := m.flushers, m.stackStore
+	m.flushers, m.stackStore = nil, nil
+	m.mu.Unlock()
+	for _, flusher := range flushers {
+		var err error
+		if shutdowner, ok := flusher.(Shutdowner); ok {
+			err = shutdowner.Shutdown(ctx)
+		} else if closer, ok := flusher.(io.Closer); ok {
+			err = closer.Close()
+		}
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
 }
 
 type Writer struct {
@@ -0,0 +1,228 @@
+package errors_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/opts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerConcurrentUse(t *testing.T) {
+	var logged int64
+	var manager errors.ErrorsManager
+	manager = errors.NewManager(errors.WithDefaultLogger(func(err errors.EnhancedError) {
+		assert.NoError(t, manager.SaveStack(err))
+	}))
+	path := filepath.Join(t.TempDir(), "stacks.jsonl")
+	assert.NoError(t, manager.Setup(path, errors.WithStackFormat(errors.StackFormatJSONL)))
+	tmpl := errors.Template().Bind(manager)
+
+	const workers = 8
+	var hash atomic.Value
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := errors.LogName(fmt.Sprintf("worker-%d", i))
+			manager.RegisterLogger(name, func(errors.EnhancedError) { atomic.AddInt64(&logged, 1) })
+			for j := 0; j < 50; j++ {
+				err := tmpl.FromEmpty()
+				hash.Store(err.GetStackTraceHash())
+				err.Log()
+				err.Log(name)
+				assert.NoError(t, manager.SaveStack(err))
+				if j%10 == 0 {
+					manager.SetFingerprinter(errors.SymbolLineFingerprint)
+					assert.NoError(t, manager.Flush(context.Background()))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(workers*50), atomic.LoadInt64(&logged))
+
+	assert.NoError(t, manager.Close(context.Background()))
+	assert.Nil(t, manager.StackStore())
+	assert.Error(t, manager.SaveStack(tmpl.FromEmpty()))
+
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL)
+	assert.NoError(t, err)
+	defer store.Close()
+	entry, ok, err := store.Get(hash.Load().(string))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(workers*50*2), entry.Count)
+}
+
+type testFlusher struct {
+	flushed, closed int
+}
+
+func (f *testFlusher) Flush(ctx context.Context) error {
+	f.flushed++
+	return nil
+}
+
+func (f *testFlusher) Close() error {
+	f.closed++
+	return nil
+}
+
+func TestManagerFlushAndClose(t *testing.T) {
+	manager := errors.NewManager()
+	flusher := &testFlusher{}
+	manager.RegisterFlusher(flusher)
+	assert.NoError(t, manager.Flush(context.Background()))
+	assert.Equal(t, 1, flusher.flushed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, manager.Flush(ctx), context.Canceled)
+
+	assert.NoError(t, manager.Close(context.Background()))
+	assert.Equal(t, 2, flusher.flushed)
+	assert.Equal(t, 1, flusher.closed)
+	assert.NoError(t, manager.Close(context.Background()))
+	assert.Equal(t, 1, flusher.closed)
+}
+
+func TestMissingLoggerPolicy(t *testing.T) {
+	var logged []errors.LogName
+	type sinkFailure struct {
+		logger errors.LogName
+		err    error
+	}
+	var failures []sinkFailure
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(func(errors.EnhancedError) { logged = append(logged, errors.DefaultLog) }),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures = append(failures, sinkFailure{logger, sinkErr})
+		}),
+	)
+	err := errors.New("missing logger").Bind(manager)
+
+	assert.Panics(t, func() { err.Log("typo") })
+	logErr := err.LogE("typo")
+	assert.ErrorIs(t, logErr, errors.ErrLoggerNotFound)
+	assert.Empty(t, logged)
+
+	manager.SetMissingLoggerPolicy(errors.MissingLoggerFallback)
+	assert.NotPanics(t, func() { err.Log("typo") })
+	assert.Equal(t, []errors.LogName{errors.DefaultLog}, logged)
+	assert.ErrorIs(t, err.LogE("typo", errors.DefaultLog), errors.ErrLoggerNotFound)
+	assert.Equal(t, []errors.LogName{errors.DefaultLog, errors.DefaultLog}, logged)
+	assert.Equal(t, int64(0), manager.DroppedLogs())
+
+	manager.SetMissingLoggerPolicy(errors.MissingLoggerDrop)
+	err.Log("typo")
+	err.Log("typo", "other")
+	assert.Len(t, logged, 2)
+	assert.Equal(t, int64(3), manager.DroppedLogs())
+
+	assert.Len(t, failures, 6)
+	for _, failure := range failures {
+		assert.NotEqual(t, errors.DefaultLog, failure.logger)
+		assert.ErrorIs(t, failure.err, errors.ErrLoggerNotFound)
+	}
+	assert.NoError(t, manager.Reset())
+	assert.Equal(t, int64(0), manager.DroppedLogs())
+}
+
+func TestSinkErrors(t *testing.T) {
+	var failures []error
+	manager := errors.NewManager(
+		errors.WithLogger("failing", func(err errors.EnhancedError) {
+			errors.ReportSinkError(err, fmt.Errorf("disk full"))
+		}),
+		errors.WithLogger("panicking", func(err errors.EnhancedError) {
+			panic("formatter failed")
+		}),
+		errors.WithLogger("saving", errors.CustomLogger(errors.WithSaveStack(true))),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures = append(failures, fmt.Errorf("%s: %w", logger, sinkErr))
+		}),
+	)
+	err := errors.New("sink error").Bind(manager)
+
+	assert.EqualError(t, err.LogE("failing"), "disk full")
+	assert.EqualError(t, err.LogE("panicking"), "logger panicking panicked: formatter failed")
+	assert.NotPanics(t, func() { err.Log("panicking") })
+	assert.EqualError(t, err.LogE("saving"), "saving stack: stack trace path not set")
+	assert.NoError(t, err.LogE(errors.DefaultLog))
+
+	errors.ReportSinkError(err, fmt.Errorf("outside of logger"))
+	var messages []string
+	for _, failure := range failures {
+		messages = append(messages, failure.Error())
+	}
+	assert.Equal(t, []string{
+		"failing: disk full",
+		"panicking: logger panicking panicked: formatter failed",
+		"panicking: logger panicking panicked: formatter failed",
+		"saving: saving stack: stack trace path not set",
+		": outside of logger",
+	}, messages)
+}
+
+var errTestBilling = errors.Template("test.billing")
+
+func TestRouting(t *testing.T) {
+	logged := make(map[errors.LogName][]string)
+	logger := func(name errors.LogName) errors.LoggerFunc {
+		return func(err errors.EnhancedError) {
+			logged[name] = append(logged[name], err.Error())
+		}
+	}
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(logger(errors.DefaultLog)),
+		errors.WithLogger("audit", logger("audit")),
+		errors.WithLogger("alerts", logger("alerts")),
+		errors.WithLogger("billing", logger("billing")),
+		errors.WithRoute(opts.IsType(opts.ErrNameAuthorization), "audit"),
+		errors.WithRoute(opts.StatusCodeAtLeast(500), "alerts"),
+	)
+	manager.Route(errors.Or(errors.IsTemplate(errTestBilling), errors.And(opts.StatusCodeBetween(400, 499), errors.Not(errors.HasOptType("title")))), "billing", "audit")
+
+	errors.New("plain").Bind(manager).Log()
+	errors.New("unauthorized").With(opts.ErrNameAuthorization, opts.StatusCode(401), opts.Title("no token")).Bind(manager).Log()
+	errors.New("outside").With(opts.ErrNameOutsideService, opts.StatusCode(502)).Bind(manager).Log()
+	errTestBilling.Bind(manager).From(fmt.Errorf("charge")).With(opts.StatusCode(503), opts.ErrNameAuthorization).Log()
+	errors.New("bad request").With(opts.StatusCode(400)).Bind(manager).Log()
+	errors.New("explicit").With(opts.StatusCode(500)).Bind(manager).Log("audit")
+
+	assert.Equal(t, map[errors.LogName][]string{
+		errors.DefaultLog: {"plain", "unauthorized", "outside", "charge", "bad request"},
+		"audit":           {"unauthorized", "charge", "bad request", "explicit"},
+		"alerts":          {"outside", "charge"},
+		"billing":         {"charge", "bad request"},
+	}, logged)
+}
+
+func TestResetKeepsTemplates(t *testing.T) {
+	assert.NoError(t, errors.Manager().Reset())
+	assert.Panics(t, func() {
+		errors.Template(errTestDuplicated.GetTemplateID())
+	}, "names registered at init should stay registered after Reset")
+}
+
+func TestRawStacksFingerprint(t *testing.T) {
+	manager := errors.NewManager()
+	assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.jsonl"), errors.WithStackFormat(errors.StackFormatJSONL), errors.WithRawStacks()))
+	defer manager.Close(context.Background())
+	err := errors.New("raw").Bind(manager)
+	// error codes stay stable across builds unless the per build fingerprinter is chosen
+	assert.Equal(t, errors.SymbolLineFingerprint(err.GetStackTrace()), err.GetStackTraceHash())
+
+	manager = errors.NewManager(errors.WithFingerprinter(errors.RawPCFingerprint))
+	assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), "stacks.jsonl"), errors.WithStackFormat(errors.StackFormatJSONL), errors.WithRawStacks()))
+	defer manager.Close(context.Background())
+	err = errors.New("raw").Bind(manager)
+	assert.Equal(t, errors.RawPCFingerprint(err.GetStackTrace()), err.GetStackTraceHash())
+}
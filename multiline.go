@@ -3,6 +3,7 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/logrusorgru/aurora"
@@ -11,42 +12,79 @@ import (
 
 func MultilineFormatter(e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) string {
 	var sb strings.Builder
-	stackTraceHash := e.GetStackTraceHash()
-	sb.WriteString(fmt.Sprintf("--- %s --- %s --- %s \n", aurora.Red("ERROR"), aurora.Blue(stackTraceHash), e.GetErrorID()))
-	var wrapper Wrapper
-	value, ok := e.GetOpts()[wrapper.Type()]
-	if ok {
-		wrapper = value.(Wrapper)
-	}
-	if wrapper != "" {
-		wrapper = Wrapper(fmt.Sprintf("%s: ", wrapper))
-	}
-	sb.WriteString(fmt.Sprintf("\tCONTENT: %s%s \n", wrapper, e.GetInternalError()))
-
-	opts := make(map[string]interface{})
-	for _, opt := range e.GetOpts() {
-		for key, value := range opt.MapFormatter() {
-			if opt.Verbosity() > verbosityThreshold {
-				continue
-			}
-			opts[key] = value
-		}
-	}
-	optBytes, err := json.MarshalIndent(opts, "\t", "  ")
-	if err != nil {
+	if err := WriteMultiline(&sb, e, verbosityThreshold, stackTraceFormatter); err != nil {
 		return "Error in Marshaling Error"
 	}
-	if len(opts) > 0 {
-		sb.WriteString("\t")
-		sb.Write(optBytes)
-		sb.WriteString("\n")
+	return sb.String()
+}
+
+// WriteMultiline writes the error formatted like MultilineFormatter to w
+func WriteMultiline(w io.Writer, e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) error {
+	mw := &multilineWriter{w: w}
+	mw.printf("--- %s --- %s --- %s \n", aurora.Red("ERROR"), aurora.Blue(e.GetStackTraceHash()), e.GetErrorID())
+	mw.printf("\tLEVEL: %s \n", SeverityName(SeverityOf(e)))
+	if err := writeMultilineLayer(mw, e, verbosityThreshold, "\t"); err != nil {
+		return err
+	}
+	for cause := e.GetCause(); cause != nil; cause = cause.GetCause() {
+		mw.printf("\tCAUSED BY: %s \n", cause.GetErrorID())
+		if err := writeMultilineLayer(mw, cause, verbosityThreshold, "\t\t"); err != nil {
+			return err
+		}
 	}
 	stackTrace := e.GetStackTrace()
 	msg := stackTraceFormatter(stackTrace)
 	if msg != "" {
-		sb.WriteString(fmt.Sprintf("\tSTACK TRACE: \n%s", msg))
+		mw.printf("\tSTACK TRACE: \n%s", msg)
 	}
-	return sb.String()
+	return mw.err
+}
+
+// multilineWriter keeps the first write error, so the record can be written without checking every write
+type multilineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *multilineWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+// writeMultilineLayer writes the template, content and options of a single error layer
+func writeMultilineLayer(mw *multilineWriter, e EnhancedError, verbosityThreshold int, indent string) error {
+	if parentIDs := e.GetParentIDs(); len(parentIDs) > 0 {
+		mw.printf("%sPARENT IDS: %s \n", indent, strings.Join(parentIDs, ", "))
+	}
+	if templateID := e.GetTemplateID(); templateID != "" {
+		mw.printf("%sTEMPLATE: %s \n", indent, strings.Join(e.GetTemplatePath(), " > "))
+	}
+	mw.printf("%sCONTENT: %s \n", indent, e.Error())
+	if wraps := formatWraps(e, verbosityThreshold); len(wraps) > 0 {
+		mw.printf("%sWRAPS: \n", indent)
+		for _, frame := range wraps {
+			mw.printf("%s\t%s at %s", indent, frame["message"], frame["location"])
+			if opts, ok := frame["opts"]; ok {
+				optBytes, err := json.Marshal(opts)
+				if err != nil {
+					return err
+				}
+				mw.printf(" %s", optBytes)
+			}
+			mw.printf("\n")
+		}
+	}
+
+	opts := formatOpts(e.GetOpts(), verbosityThreshold)
+	optBytes, err := json.MarshalIndent(opts, indent, "  ")
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		mw.printf("%s%s\n", indent, optBytes)
+	}
+	return nil
 }
 
 func MultilineStackTraceFormatter(st pkgerrors.StackTrace) string {
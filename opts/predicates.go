@@ -0,0 +1,24 @@
+package opts
+
+import "github.com/enhanced-tools/errors"
+
+// StatusCodeAtLeast matches errors with the status code greater or equal to the code, for example 500 for server errors
+func StatusCodeAtLeast(code int64) errors.Predicate {
+	return errors.OptMatches(StatusCode(0).Type(), func(opt errors.ErrorOpt) bool {
+		statusCode, ok := opt.(StatusCode)
+		return ok && int64(statusCode) >= code
+	})
+}
+
+// StatusCodeBetween matches errors with the status code within the range, including both ends
+func StatusCodeBetween(from, to int64) errors.Predicate {
+	return errors.OptMatches(StatusCode(0).Type(), func(opt errors.ErrorOpt) bool {
+		statusCode, ok := opt.(StatusCode)
+		return ok && int64(statusCode) >= from && int64(statusCode) <= to
+	})
+}
+
+// IsType matches errors of the type, for example IsType(ErrNameAuthorization)
+func IsType(t Type) errors.Predicate {
+	return errors.HasOpt(t)
+}
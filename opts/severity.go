@@ -0,0 +1,31 @@
+package opts
+
+import "github.com/enhanced-tools/errors"
+
+// Severity sets the severity of the error explicitly, overriding hints of other options. Setting it on a template
+// gives its errors a default severity.
+type Severity int
+
+const (
+	SeverityDebug   Severity = errors.LogDebug
+	SeverityInfo    Severity = errors.LogInfo
+	SeverityWarning Severity = errors.LogWarning
+	SeverityError   Severity = errors.LogError
+)
+
+func (Severity) Type() errors.ErrorOptType {
+	return errors.SeverityOptType
+}
+
+// MapFormatter returns no values, the severity is written by formatters as the level
+func (Severity) MapFormatter() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (Severity) Verbosity() int {
+	return 0
+}
+
+func (s Severity) Severity() int {
+	return int(s)
+}
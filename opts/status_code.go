@@ -17,3 +17,11 @@ func (s StatusCode) MapFormatter() map[string]interface{} {
 func (s StatusCode) Verbosity() int {
 	return 0
 }
+
+// Severity hints error severity for server errors and info severity for other status codes, which are expected
+func (s StatusCode) Severity() int {
+	if s >= 500 {
+		return errors.LogError
+	}
+	return errors.LogInfo
+}
@@ -18,6 +18,18 @@ func (t Type) Verbosity() int {
 	return 0
 }
 
+// Severity hints info severity for errors caused by the client, warning severity for denied access
+// and error severity for other errors
+func (t Type) Severity() int {
+	switch t {
+	case ErrNameResources, ErrNameParameter, ErrNameHeaders:
+		return errors.LogInfo
+	case ErrNameAuthorization, ErrNamePermissions:
+		return errors.LogWarning
+	}
+	return errors.LogError
+}
+
 const (
 	ErrNameResources      Type = "resource"
 	ErrNameParameter      Type = "parameter"
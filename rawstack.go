@@ -0,0 +1,203 @@
+package errors
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// pcAnchor is the function raw program counters are stored relative to. Offsets from a function entry do not change
+// when the position independent binary is loaded at a different address.
+func pcAnchor() {}
+
+var anchor = struct {
+	once  sync.Once
+	entry uintptr
+	name  string
+}{}
+
+func pcAnchorFunc() (uintptr, string) {
+	anchor.once.Do(func() {
+		fn := runtime.FuncForPC(reflect.ValueOf(pcAnchor).Pointer())
+		anchor.entry = fn.Entry()
+		anchor.name = fn.Name()
+	})
+	return anchor.entry, anchor.name
+}
+
+// rawPCs returns program counters of the stack trace relative to the entry of the anchor function
+func rawPCs(st errors.StackTrace) []int64 {
+	entry, _ := pcAnchorFunc()
+	pcs := make([]int64, 0, len(st))
+	for _, f := range st {
+		pcs = append(pcs, int64(uintptr(f)-entry))
+	}
+	return pcs
+}
+
+// RawPCFingerprint hashes the Go build ID and program counters relative to the anchor function, without resolving
+// them to symbols. The hash is the same in all processes of the build, also for position independent binaries,
+// but it changes with every build, so error codes can not be compared across releases. Set it with WithFingerprinter
+// together with WithRawStacks to avoid resolving symbols for error codes.
+func RawPCFingerprint(st errors.StackTrace) string {
+	buffer := bytes.Buffer{}
+	buffer.WriteString(buildID())
+	for _, pc := range rawPCs(st) {
+		buffer.WriteString(fmt.Sprintf("\n%x", pc))
+	}
+	return fmt.Sprintf("%x", md5.Sum(buffer.Bytes()))
+}
+
+var executableBuildID = struct {
+	once    sync.Once
+	buildID string
+}{}
+
+// buildID returns the Go build ID of the running program, or empty string when it can not be read
+func buildID() string {
+	executableBuildID.once.Do(func() {
+		path, err := os.Executable()
+		if err != nil {
+			return
+		}
+		file, err := elf.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		executableBuildID.buildID, _ = elfBuildID(file)
+	})
+	return executableBuildID.buildID
+}
+
+// elfBuildID reads the Go build ID from the .note.go.buildid section of the binary
+func elfBuildID(file *elf.File) (string, error) {
+	section := file.Section(".note.go.buildid")
+	if section == nil {
+		return "", fmt.Errorf("binary has no Go build ID")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", err
+	}
+	// the note consists of name size, description size and type followed by the name and the description
+	if len(data) < 16 {
+		return "", fmt.Errorf("invalid Go build ID note")
+	}
+	nameSize := file.ByteOrder.Uint32(data)
+	descSize := file.ByteOrder.Uint32(data[4:])
+	nameEnd := 12 + (nameSize+3)&^3
+	if uint32(len(data)) < nameEnd+descSize || string(bytes.TrimRight(data[12:12+nameSize], "\x00")) != "Go" {
+		return "", fmt.Errorf("invalid Go build ID note")
+	}
+	return string(data[nameEnd : nameEnd+descSize]), nil
+}
+
+// Symbolizer resolves raw program counters saved with WithRawStacks using the symbol table of the ELF binary
+// the stack traces were saved by
+type Symbolizer struct {
+	table       *gosym.Table
+	buildID     string
+	anchorEntry uint64
+}
+
+// NewSymbolizer reads the symbol table of the ELF binary
+func NewSymbolizer(binaryPath string) (*Symbolizer, error) {
+	file, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	text := file.Section(".text")
+	if text == nil {
+		return nil, fmt.Errorf("binary has no .text section")
+	}
+	pclntab, err := elfPCLineTable(file)
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, text.Addr))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading symbol table")
+	}
+	_, anchorName := pcAnchorFunc()
+	anchorFn := table.LookupFunc(anchorName)
+	if anchorFn == nil {
+		return nil, fmt.Errorf("binary has no %s function, it does not use the errors package", anchorName)
+	}
+	s := &Symbolizer{table: table, anchorEntry: anchorFn.Entry}
+	s.buildID, _ = elfBuildID(file)
+	return s, nil
+}
+
+// elfPCLineTable returns the Go line table of the binary. Position independent binaries keep it in the relocated data.
+func elfPCLineTable(file *elf.File) ([]byte, error) {
+	for _, name := range []string{".gopclntab", ".data.rel.ro.gopclntab"} {
+		if section := file.Section(name); section != nil {
+			return section.Data()
+		}
+	}
+	symbols, err := file.Symbols()
+	if err != nil {
+		return nil, errors.Wrap(err, "binary has no Go line table")
+	}
+	var start, end *elf.Symbol
+	for i := range symbols {
+		switch symbols[i].Name {
+		case "runtime.pclntab":
+			start = &symbols[i]
+		case "runtime.epclntab":
+			end = &symbols[i]
+		}
+	}
+	if start == nil || end == nil || int(start.Section) >= len(file.Sections) {
+		return nil, fmt.Errorf("binary has no Go line table")
+	}
+	section := file.Sections[start.Section]
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	if start.Value < section.Addr || end.Value > section.Addr+uint64(len(data)) || end.Value < start.Value {
+		return nil, fmt.Errorf("invalid Go line table")
+	}
+	return data[start.Value-section.Addr : end.Value-section.Addr], nil
+}
+
+// BuildID returns the Go build ID of the binary
+func (s *Symbolizer) BuildID() string {
+	return s.buildID
+}
+
+// Symbolize returns the entry with frames resolved from its raw program counters. It fails when the entry
+// was saved by other build of the program.
+func (s *Symbolizer) Symbolize(entry StackEntry) (StackEntry, error) {
+	if len(entry.PCs) == 0 {
+		return entry, nil
+	}
+	if entry.BuildID != "" && s.buildID != "" && entry.BuildID != s.buildID {
+		return entry, fmt.Errorf("stack %s was saved by build %s, binary is build %s", entry.Hash, entry.BuildID, s.buildID)
+	}
+	frames := make([]StackFrame, 0, len(entry.PCs))
+	for _, offset := range entry.PCs {
+		// program counters are return addresses, subtracting one gives the address of the call
+		pc := uint64(int64(s.anchorEntry)+offset) - 1
+		file, line, fn := s.table.PCToLine(pc)
+		if fn == nil {
+			frames = append(frames, StackFrame{SourceFile: "unknown", SourceLine: "0", FunctionName: "unknown"})
+			continue
+		}
+		frames = append(frames, StackFrame{SourceFile: file, SourceLine: fmt.Sprintf("%d", line), FunctionName: fn.Name})
+	}
+	entry.Frames = frames
+	entry.Trace = ""
+	return entry, nil
+}
@@ -0,0 +1,120 @@
+package errors
+
+import (
+	"sort"
+)
+
+// StackChange is a stack trace whose occurrences differ between the compared releases
+type StackChange struct {
+	Hash string
+	// Entry is the stack entry of the new release, or of the old one when the stack trace disappeared
+	Entry    StackEntry
+	OldCount int64
+	NewCount int64
+}
+
+// StackComparison lists stack traces which appeared, disappeared and changed frequency between two releases
+type StackComparison struct {
+	Added   []StackChange
+	Removed []StackChange
+	Changed []StackChange
+}
+
+// stackCounts are occurrences of stack traces of a single release
+type stackCounts struct {
+	entries map[string]StackEntry
+	counts  map[string]int64
+	total   int64
+}
+
+func newStackCounts() *stackCounts {
+	return &stackCounts{entries: make(map[string]StackEntry), counts: make(map[string]int64)}
+}
+
+func (c *stackCounts) add(entry StackEntry, count int64) {
+	if count <= 0 {
+		return
+	}
+	if _, ok := c.entries[entry.Hash]; !ok {
+		c.entries[entry.Hash] = entry
+	}
+	c.counts[entry.Hash] += count
+	c.total += count
+}
+
+// CompareStacks compares stack traces saved by the old and the new release. Stack traces are reported as changed when
+// their share of all occurrences of the release grew or shrank by at least factor. Zero factor does not report changes.
+func CompareStacks(oldStore, newStore StackStore, factor float64) (StackComparison, error) {
+	oldCounts, newCounts := newStackCounts(), newStackCounts()
+	err := oldStore.Each(func(entry StackEntry) error {
+		oldCounts.add(entry, occurrences(entry))
+		return nil
+	})
+	if err != nil {
+		return StackComparison{}, err
+	}
+	err = newStore.Each(func(entry StackEntry) error {
+		newCounts.add(entry, occurrences(entry))
+		return nil
+	})
+	if err != nil {
+		return StackComparison{}, err
+	}
+	return compareStackCounts(oldCounts, newCounts, factor), nil
+}
+
+// CompareRevisions compares stack traces saved by two VCS revisions of the program into the same stack store.
+// Occurrences per revision are tracked by the JSON Lines and the memory stack stores, other stores record only
+// the revision which saved the stack trace first. Changes are reported the same way as by CompareStacks.
+func CompareRevisions(store StackStore, oldRevision, newRevision string, factor float64) (StackComparison, error) {
+	oldCounts, newCounts := newStackCounts(), newStackCounts()
+	err := store.Each(func(entry StackEntry) error {
+		counts := revisionCounts(entry)
+		oldCounts.add(entry, counts[oldRevision])
+		newCounts.add(entry, counts[newRevision])
+		return nil
+	})
+	if err != nil {
+		return StackComparison{}, err
+	}
+	return compareStackCounts(oldCounts, newCounts, factor), nil
+}
+
+func compareStackCounts(oldCounts, newCounts *stackCounts, factor float64) StackComparison {
+	var comparison StackComparison
+	for hash, entry := range newCounts.entries {
+		change := StackChange{Hash: hash, Entry: entry, OldCount: oldCounts.counts[hash], NewCount: newCounts.counts[hash]}
+		if change.OldCount == 0 {
+			comparison.Added = append(comparison.Added, change)
+			continue
+		}
+		if factor <= 0 {
+			continue
+		}
+		oldShare := float64(change.OldCount) / float64(oldCounts.total)
+		newShare := float64(change.NewCount) / float64(newCounts.total)
+		if newShare >= oldShare*factor || oldShare >= newShare*factor {
+			comparison.Changed = append(comparison.Changed, change)
+		}
+	}
+	for hash, entry := range oldCounts.entries {
+		if _, ok := newCounts.entries[hash]; !ok {
+			comparison.Removed = append(comparison.Removed, StackChange{Hash: hash, Entry: entry, OldCount: oldCounts.counts[hash]})
+		}
+	}
+	for _, changes := range [][]StackChange{comparison.Added, comparison.Removed, comparison.Changed} {
+		sortStackChanges(changes)
+	}
+	return comparison
+}
+
+// sortStackChanges sorts changes by the number of occurrences, the most frequent first
+func sortStackChanges(changes []StackChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i].OldCount+changes[i].NewCount, changes[j].OldCount+changes[j].NewCount
+		if a != b {
+			return a > b
+		}
+		return changes[i].Hash < changes[j].Hash
+	})
+}
@@ -0,0 +1,72 @@
+package errors
+
+import "reflect"
+
+// Predicate reports whether the error matches a routing rule
+type Predicate func(err EnhancedError) bool
+
+// And matches errors matching all the predicates
+func And(predicates ...Predicate) Predicate {
+	return func(err EnhancedError) bool {
+		for _, predicate := range predicates {
+			if !predicate(err) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches errors matching any of the predicates
+func Or(predicates ...Predicate) Predicate {
+	return func(err EnhancedError) bool {
+		for _, predicate := range predicates {
+			if predicate(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches errors not matching the predicate
+func Not(predicate Predicate) Predicate {
+	return func(err EnhancedError) bool {
+		return !predicate(err)
+	}
+}
+
+// HasOpt matches errors with the option of the same type and value, for example HasOpt(opts.ErrNameAuthorization)
+func HasOpt(opt ErrorOpt) Predicate {
+	return OptMatches(opt.Type(), func(value ErrorOpt) bool {
+		return reflect.DeepEqual(value, opt)
+	})
+}
+
+// HasOptType matches errors with an option of the type
+func HasOptType(optType ErrorOptType) Predicate {
+	return OptMatches(optType, func(ErrorOpt) bool {
+		return true
+	})
+}
+
+// OptMatches matches errors with an option of the type for which fn returns true
+func OptMatches(optType ErrorOptType, fn func(opt ErrorOpt) bool) Predicate {
+	return func(err EnhancedError) bool {
+		opt, ok := err.GetOpts()[optType]
+		return ok && fn(opt)
+	}
+}
+
+// IsTemplate matches errors created from the template or from templates derived from it
+func IsTemplate(template EnhancedError) Predicate {
+	return func(err EnhancedError) bool {
+		return err.Is(template)
+	}
+}
+
+// route sends errors matching the predicate to the loggers
+type route struct {
+	predicate Predicate
+	loggers   []LogName
+}
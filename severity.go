@@ -0,0 +1,47 @@
+package errors
+
+// SeverityOptType is the type of the option setting the severity of the error explicitly
+const SeverityOptType ErrorOptType = "severity"
+
+// SeverityHint is implemented by options hinting the severity of the error. Severity is one of LogDebug, LogInfo,
+// LogWarning and LogError.
+type SeverityHint interface {
+	Severity() int
+}
+
+// SeverityOf returns the severity of the error. The option of SeverityOptType wins, otherwise the most severe hint
+// of other options is used. Errors without hints have LogError severity.
+func SeverityOf(e EnhancedError) int {
+	errorOpts := e.GetOpts()
+	if hint, ok := errorOpts[SeverityOptType].(SeverityHint); ok {
+		return hint.Severity()
+	}
+	severity, hinted := 0, false
+	for _, opt := range errorOpts {
+		hint, ok := opt.(SeverityHint)
+		if !ok {
+			continue
+		}
+		if !hinted || hint.Severity() > severity {
+			severity = hint.Severity()
+			hinted = true
+		}
+	}
+	if !hinted {
+		return LogError
+	}
+	return severity
+}
+
+// SeverityName returns the name of the severity written as the level by formatters
+func SeverityName(severity int) string {
+	switch {
+	case severity <= LogDebug:
+		return "debug"
+	case severity == LogInfo:
+		return "info"
+	case severity == LogWarning:
+		return "warning"
+	}
+	return "error"
+}
@@ -0,0 +1,274 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// rotationOpts describes when the stack file is rotated and how many rotated generations are kept
+type rotationOpts struct {
+	maxSize   int64
+	maxAge    time.Duration
+	retention int
+}
+
+// createdMarkerPrefix starts the first line of stack files rotated by age. The line records when the file was started,
+// so its age does not depend on when the process opened it. Readers skip it, as it precedes all stack traces.
+const createdMarkerPrefix = "# created "
+
+func createdMarker(created time.Time) []byte {
+	return []byte(createdMarkerPrefix + created.UTC().Format(time.RFC3339Nano) + "\n")
+}
+
+// readCreatedMarker returns the time recorded on the first line of the file, if the line is the created marker
+func readCreatedMarker(r io.ReaderAt) (time.Time, bool) {
+	buf := make([]byte, len(createdMarkerPrefix)+len(time.RFC3339Nano)+1)
+	n, _ := r.ReadAt(buf, 0)
+	line := string(buf[:n])
+	end := strings.IndexByte(line, '\n')
+	if end < 0 || !strings.HasPrefix(line, createdMarkerPrefix) {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(time.RFC3339Nano, line[len(createdMarkerPrefix):end])
+	return created, err == nil
+}
+
+// sharedFile is an append only file which can be shared between processes. Appends are serialized with
+// the advisory file lock and content appended by other processes is read before every append.
+// When rotation is enabled, the file is renamed to path.1 (path.1 to path.2 and so on) and a new file is started.
+type sharedFile struct {
+	path     string
+	file     *os.File
+	offset   int64
+	openedAt time.Time
+	// createdAt is read from the created marker of the file, it is zero for files started without the marker
+	createdAt time.Time
+	rotation  rotationOpts
+	// read parses content of the file appended since the last read
+	read func(r io.Reader) error
+	// reset is called when the file was rotated, before the content of the new file is read
+	reset func()
+}
+
+func openSharedFile(path string, rotation rotationOpts, read func(r io.Reader) error, reset func()) (*sharedFile, error) {
+	f := &sharedFile{path: path, rotation: rotation, read: read, reset: reset}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if err := f.refresh(); err != nil {
+		f.file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *sharedFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.offset = 0
+	f.openedAt = time.Now()
+	f.createdAt = time.Time{}
+	return nil
+}
+
+// lock acquires the file lock. When the file was rotated by other process, the new file is opened and locked instead.
+func (f *sharedFile) lock() error {
+	for {
+		if err := lockFile(f.file); err != nil {
+			return err
+		}
+		current, err := f.file.Stat()
+		if err != nil {
+			unlockFile(f.file)
+			return err
+		}
+		onDisk, err := os.Stat(f.path)
+		if err == nil && os.SameFile(current, onDisk) {
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			unlockFile(f.file)
+			return err
+		}
+		unlockFile(f.file)
+		f.file.Close()
+		if err := f.open(); err != nil {
+			return err
+		}
+		f.reset()
+	}
+}
+
+// refresh reads content appended by other processes since the last read
+func (f *sharedFile) refresh() error {
+	if err := f.lock(); err != nil {
+		return err
+	}
+	defer unlockFile(f.file)
+	return f.readNew()
+}
+
+func (f *sharedFile) readNew() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= f.offset {
+		return nil
+	}
+	if f.offset == 0 {
+		f.createdAt, _ = readCreatedMarker(f.file)
+	}
+	if err := f.read(io.NewSectionReader(f.file, f.offset, info.Size()-f.offset)); err != nil {
+		return err
+	}
+	f.offset = info.Size()
+	return nil
+}
+
+// append reads content appended by other processes and then appends data returned by fn while holding the file lock.
+// The file is rotated before fn is called when it exceeds the rotation limits. When fn returns no data nothing is written.
+func (f *sharedFile) append(fn func() ([]byte, error)) error {
+	if err := f.lock(); err != nil {
+		return err
+	}
+	if f.shouldRotate() {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	defer unlockFile(f.file)
+	if err := f.readNew(); err != nil {
+		return err
+	}
+	data, err := fn()
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	if f.offset == 0 && f.rotation.maxAge > 0 {
+		f.createdAt = time.Now()
+		data = append(createdMarker(f.createdAt), data...)
+	}
+	n, err := f.file.Write(data)
+	f.offset += int64(n)
+	return err
+}
+
+func (f *sharedFile) shouldRotate() bool {
+	if f.rotation.maxSize <= 0 && f.rotation.maxAge <= 0 {
+		return false
+	}
+	info, err := f.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false
+	}
+	if f.rotation.maxSize > 0 && info.Size() >= f.rotation.maxSize {
+		return true
+	}
+	startedAt := f.createdAt
+	if startedAt.IsZero() {
+		startedAt = f.openedAt
+	}
+	return f.rotation.maxAge > 0 && time.Since(startedAt) >= f.rotation.maxAge
+}
+
+// rotate moves the locked file to the first generation and opens and locks a new one
+func (f *sharedFile) rotate() error {
+	err := rotateGenerations(f.path, f.rotation.retention)
+	unlockFile(f.file)
+	f.file.Close()
+	if err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.reset()
+	return f.lock()
+}
+
+// sync commits the written content to disk
+func (f *sharedFile) sync() error {
+	return f.file.Sync()
+}
+
+func (f *sharedFile) Close() error {
+	return f.file.Close()
+}
+
+// generationPath returns path of the rotated generation. Generation 0 is the current file.
+func generationPath(path string, generation int) string {
+	if generation == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, generation)
+}
+
+// rotatedGenerations returns paths of the existing rotated generations, starting with the oldest one
+func rotatedGenerations(path string) []string {
+	var paths []string
+	for generation := 1; ; generation++ {
+		if _, err := os.Stat(generationPath(path, generation)); err != nil {
+			break
+		}
+		paths = append([]string{generationPath(path, generation)}, paths...)
+	}
+	return paths
+}
+
+// stackFileGenerations returns paths of all generations of the stack file, starting with the oldest one
+func stackFileGenerations(path string) []string {
+	return append(rotatedGenerations(path), path)
+}
+
+// rotateGenerations shifts rotated generations by one, removing the ones exceeding the retention,
+// and moves the current file to the first generation
+func rotateGenerations(path string, retention int) error {
+	generations := len(rotatedGenerations(path))
+	for generation := generations; generation >= retention && generation > 0; generation-- {
+		if err := os.Remove(generationPath(path, generation)); err != nil {
+			return err
+		}
+	}
+	if retention == 0 {
+		return os.Remove(path)
+	}
+	for generation := retention - 1; generation >= 0; generation-- {
+		err := os.Rename(generationPath(path, generation), generationPath(path, generation+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGenerations reads all generations of the stack file, starting with the oldest one
+func readGenerations(path string, read func(r io.Reader, modTime time.Time) error) error {
+	for _, generation := range stackFileGenerations(path) {
+		err := func() error {
+			file, err := os.Open(generation)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			return read(file, info.ModTime())
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
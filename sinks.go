@@ -0,0 +1,124 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Sink receives errors formatted by CustomLogger. The record is valid only during the call, sinks keeping it
+// must copy it.
+type Sink interface {
+	WriteError(e EnhancedError, record []byte) error
+}
+
+// SinkFunc is a function implementing Sink
+type SinkFunc func(e EnhancedError, record []byte) error
+
+func (f SinkFunc) WriteError(e EnhancedError, record []byte) error {
+	return f(e, record)
+}
+
+// StreamFormatter writes the formatted error to w. Unlike ErrorFormatter it does not build the whole record in memory.
+type StreamFormatter func(w io.Writer, e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) error
+
+// streamErrorFormatter adapts the formatter returning a string to StreamFormatter
+func streamErrorFormatter(formatter ErrorFormatter) StreamFormatter {
+	return func(w io.Writer, e EnhancedError, verbosityThreshold int, stackTraceFormatter StackTraceFormatter) error {
+		_, err := io.WriteString(w, formatter(e, verbosityThreshold, stackTraceFormatter))
+		return err
+	}
+}
+
+type writerSink struct {
+	w *Writer
+}
+
+// NewWriterSink returns the sink writing every record to w with a single write call, ending it with a new line.
+// Writes are serialized, so records of concurrent errors are not interleaved.
+func NewWriterSink(w io.Writer) Sink {
+	writer, ok := w.(*Writer)
+	if !ok {
+		writer = NewWriter(w)
+	}
+	return &writerSink{w: writer}
+}
+
+func (s *writerSink) WriteError(e EnhancedError, record []byte) error {
+	if len(record) == 0 || record[len(record)-1] != '\n' {
+		record = append(record[:len(record):len(record)], '\n')
+	}
+	_, err := s.w.Write(record)
+	return err
+}
+
+type stdLoggerSink struct {
+	logger *log.Logger
+}
+
+// NewStdLoggerSink returns the sink printing records with the logger, using its prefix and flags.
+// Nil logger prints with the standard logger of the log package.
+func NewStdLoggerSink(logger *log.Logger) Sink {
+	return &stdLoggerSink{logger: logger}
+}
+
+func (s *stdLoggerSink) WriteError(e EnhancedError, record []byte) error {
+	if s.logger == nil {
+		return log.Output(2, string(record))
+	}
+	return s.logger.Output(2, string(record))
+}
+
+// recordBuffers are reused for formatting records
+var recordBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// sinkField is the field of the error passed to sinks with structured fields, like syslog or journald
+type sinkField struct {
+	key   string
+	value string
+}
+
+// sinkFields returns the error ID, stack trace hash, template and options with verbosity up to the threshold,
+// ordered by key. Options which are not strings or numbers are encoded as JSON.
+func sinkFields(e EnhancedError, verbosityThreshold int) []sinkField {
+	if e == nil {
+		return nil
+	}
+	fields := []sinkField{
+		{key: "errorID", value: e.GetErrorID()},
+		{key: "errorCode", value: e.GetStackTraceHash()},
+	}
+	if templateID := e.GetTemplateID(); templateID != "" {
+		fields = append(fields, sinkField{key: "template", value: templateID})
+	}
+	opts := formatOpts(e.GetOpts(), verbosityThreshold)
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, sinkField{key: key, value: sinkFieldValue(opts[key])})
+	}
+	return fields
+}
+
+func sinkFieldValue(value interface{}) string {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		valueBytes, err := json.Marshal(value)
+		if err == nil {
+			return string(valueBytes)
+		}
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,595 @@
+package errors_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/enhanced-tools/errors"
+	"github.com/enhanced-tools/errors/opts"
+	"github.com/stretchr/testify/assert"
+)
+
+func readGzip(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	content, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.log")
+	sink, err := errors.NewFileSink(path, errors.WithFileRotation(10, 0), errors.WithFileBackups(2), errors.WithReopenOnSIGHUP(false))
+	assert.NoError(t, err)
+	for _, record := range []string{"record-1", "record-2", "record-3", "record-4"} {
+		assert.NoError(t, sink.WriteError(nil, []byte(record)))
+	}
+	// rotated files are compressed in the background, flush waits for them
+	assert.NoError(t, sink.Flush(context.Background()))
+	assert.Equal(t, "record-3\n", readGzip(t, path+".1.gz"))
+	assert.NoError(t, sink.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "record-4\n", string(content))
+	assert.Equal(t, "record-3\n", readGzip(t, path+".1.gz"))
+	assert.Equal(t, "record-2\n", readGzip(t, path+".2.gz"))
+	assert.NoFileExists(t, path+".3.gz")
+	assert.NoFileExists(t, path+".1")
+	assert.Error(t, sink.WriteError(nil, []byte("closed")))
+
+	path = filepath.Join(t.TempDir(), "errors.log")
+	sink, err = errors.NewFileSink(path, errors.WithFileCompression(false), errors.WithReopenOnSIGHUP(false))
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-1\n")))
+	assert.NoError(t, sink.Rotate())
+	assert.NoError(t, sink.WriteError(nil, []byte("record-2\n")))
+	assert.NoError(t, sink.Close())
+	content, err = os.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, "record-1\n", string(content))
+}
+
+func TestFileSinkRotationByFileAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "errors.log")
+	created := filepath.Join(dir, ".errors.log.created")
+	sink, err := errors.NewFileSink(path, errors.WithFileRotation(0, time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-1")))
+	assert.NoError(t, sink.Close())
+	assert.FileExists(t, created)
+
+	// the file was created before the restart, its age does not start again
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.WriteFile(created, []byte(old.UTC().Format(time.RFC3339Nano)), 0666))
+	assert.NoError(t, os.Chtimes(path, old, old))
+	sink, err = errors.NewFileSink(path, errors.WithFileRotation(0, time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-2")))
+	assert.NoError(t, sink.WriteError(nil, []byte("record-3")))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, "record-1\n", readGzip(t, path+".1.gz"))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "record-2\nrecord-3\n", string(content))
+}
+
+func TestFileSinkShiftsUncompressedBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.log")
+	// left uncompressed when its compression failed
+	assert.NoError(t, os.WriteFile(path+".1", []byte("leftover\n"), 0666))
+	sink, err := errors.NewFileSink(path)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-1")))
+	assert.NoError(t, sink.Rotate())
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, "record-1\n", readGzip(t, path+".1.gz"))
+	content, err := os.ReadFile(path + ".2")
+	assert.NoError(t, err)
+	assert.Equal(t, "leftover\n", string(content))
+}
+
+func TestFileSinkReopen(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("open files can not be moved on windows")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "errors.log")
+	sink, err := errors.NewFileSink(path, errors.WithReopenOnSIGHUP(true))
+	assert.NoError(t, err)
+	defer sink.Close()
+	manager := errors.NewManager(errors.WithDefaultLogger(errors.CustomLogger(
+		errors.WithStreamFormatter(errors.WriteLogFMT),
+		errors.WithSink(sink),
+	)))
+	manager.RegisterFlusher(sink)
+
+	errors.New("before reopen").Bind(manager).Log()
+	assert.NoError(t, os.Rename(path, filepath.Join(dir, "errors.log.moved")))
+	assert.NoError(t, sink.Reopen())
+	errors.New("after reopen").Bind(manager).Log()
+
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		assert.NoError(t, os.Rename(path, filepath.Join(dir, "errors.log.signaled")))
+		process, err := os.FindProcess(os.Getpid())
+		assert.NoError(t, err)
+		assert.NoError(t, process.Signal(syscall.SIGHUP))
+		assert.Eventually(t, func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		errors.New("after signal").Bind(manager).Log()
+		signaled, err := os.ReadFile(filepath.Join(dir, "errors.log.signaled"))
+		assert.NoError(t, err)
+		assert.Contains(t, string(signaled), "content=\"after reopen\"")
+	}
+	assert.NoError(t, manager.Close(context.Background()))
+
+	moved, err := os.ReadFile(filepath.Join(dir, "errors.log.moved"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(moved), "content=\"before reopen\"")
+	assert.Equal(t, 1, strings.Count(string(moved), "errorID="))
+	current, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(current), "errorID="))
+}
+
+// recordingSink collects written records. Writes block while the sink is paused.
+type recordingSink struct {
+	mu      sync.Mutex
+	records []string
+	paused  chan struct{}
+	closed  bool
+}
+
+func (s *recordingSink) WriteError(e errors.EnhancedError, record []byte) error {
+	if s.paused != nil {
+		<-s.paused
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, string(record))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.records...)
+}
+
+func TestBatchSink(t *testing.T) {
+	inner := &recordingSink{}
+	sink := errors.NewBatchSink(inner, errors.WithBatchSize(2), errors.WithFlushInterval(time.Hour))
+	manager := errors.NewManager()
+	manager.RegisterFlusher(sink)
+
+	assert.NoError(t, sink.WriteError(nil, []byte("record-1")))
+	assert.Equal(t, 1, sink.Queued())
+	assert.Empty(t, inner.written())
+	assert.NoError(t, sink.WriteError(nil, []byte("record-2")))
+	assert.Eventually(t, func() bool {
+		return len(inner.written()) == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-3")))
+	assert.NoError(t, manager.Flush(context.Background()))
+	assert.Equal(t, []string{"record-1", "record-2", "record-3"}, inner.written())
+
+	assert.NoError(t, sink.WriteError(nil, []byte("record-4")))
+	assert.NoError(t, manager.Close(context.Background()))
+	assert.Len(t, inner.written(), 4)
+	assert.True(t, inner.closed)
+	assert.ErrorIs(t, sink.WriteError(nil, []byte("record-5")), errors.ErrSinkClosed)
+
+	sink = errors.NewBatchSink(inner, errors.WithFlushInterval(time.Millisecond))
+	assert.NoError(t, sink.WriteError(nil, []byte("record-6")))
+	assert.Eventually(t, func() bool {
+		return len(inner.written()) == 5
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(t, sink.Close())
+}
+
+func TestBatchSinkWithoutFlushInterval(t *testing.T) {
+	inner := &recordingSink{}
+	sink := errors.NewBatchSink(inner, errors.WithBatchSize(2), errors.WithFlushInterval(0))
+	assert.NoError(t, sink.WriteError(nil, []byte("record-1")))
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, inner.written(), "queued errors should wait for the full batch")
+	assert.NoError(t, sink.WriteError(nil, []byte("record-2")))
+	assert.Eventually(t, func() bool {
+		return len(inner.written()) == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(t, sink.WriteError(nil, []byte("record-3")))
+	assert.NoError(t, sink.Flush(context.Background()))
+	assert.Equal(t, []string{"record-1", "record-2", "record-3"}, inner.written())
+	assert.NoError(t, sink.Close())
+}
+
+func TestBatchSinkOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy   errors.OverflowPolicy
+		expected []string
+		dropped  int64
+	}{
+		{errors.OverflowDropNewest, []string{"record-1", "record-2", "record-3"}, 1},
+		{errors.OverflowDropOldest, []string{"record-1", "record-3", "record-4"}, 1},
+		{errors.OverflowBlock, []string{"record-1", "record-2", "record-3", "record-4"}, 0},
+	} {
+		inner := &recordingSink{paused: make(chan struct{})}
+		sink := errors.NewBatchSink(inner, errors.WithQueueSize(2), errors.WithBatchSize(1), errors.WithOverflowPolicy(tc.policy))
+		assert.NoError(t, sink.WriteError(nil, []byte("record-1")))
+		// wait until the first record is being written
+		assert.Eventually(t, func() bool {
+			return sink.Queued() == 0
+		}, 5*time.Second, time.Millisecond)
+		assert.NoError(t, sink.WriteError(nil, []byte("record-2")))
+		assert.NoError(t, sink.WriteError(nil, []byte("record-3")))
+
+		written := make(chan error)
+		go func() {
+			written <- sink.WriteError(nil, []byte("record-4"))
+		}()
+		if tc.policy == errors.OverflowBlock {
+			select {
+			case <-written:
+				t.Error("write did not block when the queue was full")
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(inner.paused)
+			assert.NoError(t, <-written)
+		} else {
+			assert.NoError(t, <-written)
+			close(inner.paused)
+		}
+		assert.NoError(t, sink.Flush(context.Background()))
+		assert.Equal(t, tc.expected, inner.written())
+		assert.Equal(t, tc.dropped, sink.Dropped())
+		assert.NoError(t, sink.Close())
+	}
+}
+
+func TestBatchSinkFailures(t *testing.T) {
+	type failure struct {
+		logger  errors.LogName
+		sinkErr error
+	}
+	failures := make(chan failure, 2)
+	sink := errors.NewBatchSink(errors.SinkFunc(func(e errors.EnhancedError, record []byte) error {
+		if strings.Contains(string(record), "panicking") {
+			panic("sink failure")
+		}
+		return fmt.Errorf("connection refused")
+	}))
+	manager := errors.NewManager(
+		errors.WithLogger("async", errors.CustomLogger(errors.WithSink(sink))),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures <- failure{logger: logger, sinkErr: sinkErr}
+		}),
+	)
+	manager.RegisterFlusher(sink)
+
+	assert.NoError(t, errors.New("failing").Bind(manager).LogE("async"))
+	errors.New("panicking").Bind(manager).Log("async")
+	assert.NoError(t, manager.Close(context.Background()))
+
+	first := <-failures
+	assert.Equal(t, errors.LogName("async"), first.logger)
+	assert.EqualError(t, first.sinkErr, "writing error: connection refused")
+	second := <-failures
+	assert.Equal(t, errors.LogName("async"), second.logger)
+	assert.EqualError(t, second.sinkErr, "sink panicked: sink failure")
+}
+
+var errTestSyslog = errors.Template("test.syslog")
+
+func TestSyslogSink(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	sink, err := errors.NewSyslogSink("udp", listener.LocalAddr().String(),
+		errors.WithSyslogFacility(errors.FacilityLocal0),
+		errors.WithSyslogAppName("app"),
+		errors.WithSyslogHostname("host"),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Close()
+	logger := errors.CustomLogger(
+		errors.WithStreamFormatter(errors.WriteLogFMT),
+		errors.WithStackTraceFormatter(errors.NoStackTrace),
+		errors.WithSink(sink),
+	)
+
+	err1 := errTestSyslog.From(fmt.Errorf("not found")).With(opts.StatusCode(404), opts.RequestID(`req"1]`))
+	logger(err1)
+	buf := make([]byte, 64*1024)
+	n, _, err := listener.ReadFrom(buf)
+	assert.NoError(t, err)
+	expected := fmt.Sprintf(`^<134>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ host app %d test\.syslog `, os.Getpid()) +
+		regexp.QuoteMeta(fmt.Sprintf(`[error@32473 errorID="%s" errorCode="%s" template="test.syslog" requestID="req\"1\]" statusCode="404"] errorID=%s`,
+			err1.GetErrorID(), err1.GetStackTraceHash(), err1.GetErrorID()))
+	assert.Regexp(t, expected, string(buf[:n]))
+	assert.NotContains(t, string(buf[:n]), "\n")
+
+	logger(errors.New("internal").With(opts.StatusCode(500)))
+	n, _, err = listener.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^<131>1 \S+ host app \d+ - \[error@32473 errorID="[\w-]+" errorCode="\w+" statusCode="500"\] errorID=`, string(buf[:n]))
+}
+
+func TestSyslogSinkStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	sink, err := errors.NewSyslogSink("tcp", listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Close()
+	conn, err := listener.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	logger := errors.CustomLogger(errors.WithSink(sink))
+	logger(errors.New("first"))
+	logger(errors.New("second"))
+	reader := bufio.NewReader(conn)
+	for _, content := range []string{"first", "second"} {
+		size, err := reader.ReadString(' ')
+		assert.NoError(t, err)
+		length, err := strconv.Atoi(strings.TrimSpace(size))
+		assert.NoError(t, err)
+		message := make([]byte, length)
+		_, err = io.ReadFull(reader, message)
+		assert.NoError(t, err)
+		assert.Regexp(t, `^<11>1 `, string(message))
+		// multiline records are kept in octet counted frames
+		assert.Contains(t, string(message), "\tCONTENT: "+content+" \n")
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	dir, err := os.MkdirTemp("", "syslog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "log"), Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer socket.Close()
+	sink, err = errors.NewSyslogSink("unixgram", filepath.Join(dir, "log"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Close()
+	errors.CustomLogger(errors.WithSink(sink))(errors.New("local").With(opts.SeverityWarning))
+	buf := make([]byte, 64*1024)
+	n, err := socket.Read(buf)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^<12>1 `, string(buf[:n]))
+}
+
+func TestWebhookSink(t *testing.T) {
+	secret := []byte("secret")
+	var mu sync.Mutex
+	var batches [][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get(errors.WebhookSignatureHeader))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if !assert.NoError(t, err) {
+			return
+		}
+		var batch []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(zr).Decode(&batch))
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := errors.NewWebhookSink(server.URL,
+		errors.WithWebhookSecret(secret),
+		errors.WithWebhookHeader("Authorization", "Bearer token"),
+		errors.WithWebhookCompression(true),
+		errors.WithWebhookBatch(errors.WithBatchSize(2), errors.WithFlushInterval(time.Hour)),
+	)
+	manager := errors.NewManager(errors.WithDefaultLogger(errors.CustomLogger(errors.WithSink(sink))))
+	manager.RegisterFlusher(sink)
+	for i := 0; i < 3; i++ {
+		errors.New(fmt.Sprintf("error-%d", i)).With(opts.StatusCode(500 + i)).Bind(manager).Log()
+	}
+	assert.NoError(t, manager.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, batches, 2) {
+		return
+	}
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+	last := batches[1][0]
+	assert.Equal(t, float64(502), last["statusCode"])
+	assert.Equal(t, "error", last["level"])
+	assert.NotEmpty(t, last["errorID"])
+	assert.NotEmpty(t, last["stackTrace"])
+}
+
+func TestWebhookSinkCloseSendsQueued(t *testing.T) {
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		atomic.AddInt64(&received, int64(len(batch)))
+	}))
+	defer server.Close()
+
+	failures := make(chan error, 10)
+	sink := errors.NewWebhookSink(server.URL, errors.WithWebhookBatch(errors.WithFlushInterval(time.Hour)))
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(errors.CustomLogger(errors.WithSink(sink))),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures <- sinkErr
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		errors.New("queued").Bind(manager).Log()
+	}
+	// closed directly, without flushing the manager first
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, int64(3), atomic.LoadInt64(&received))
+	assert.Empty(t, failures)
+}
+
+// signalingTransport signals every response received by the client
+type signalingTransport struct {
+	responses chan struct{}
+}
+
+func (t signalingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	t.responses <- struct{}{}
+	return resp, err
+}
+
+func TestWebhookSinkShutdownCancelsRetries(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	failures := make(chan error, 10)
+	transport := signalingTransport{responses: make(chan struct{}, 10)}
+	sink := errors.NewWebhookSink(server.URL,
+		errors.WithWebhookClient(&http.Client{Transport: transport}),
+		errors.WithWebhookRetries(5, time.Hour, time.Hour),
+		errors.WithWebhookBatch(errors.WithBatchSize(1)),
+	)
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(errors.CustomLogger(errors.WithSink(sink))),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures <- sinkErr
+		}),
+	)
+	errors.New("webhook").Bind(manager).Log()
+	// the 503 response was received, the batch waits for the retry backoff
+	<-transport.responses
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	closed := make(chan error)
+	go func() {
+		closed <- sink.Shutdown(ctx)
+	}()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown should not wait for the retry backoff")
+	}
+	assert.EqualError(t, <-failures, "writing error: collector responded with 503 Service Unavailable")
+	assert.Equal(t, int64(1), atomic.LoadInt64(&requests))
+}
+
+func TestWebhookSinkFailures(t *testing.T) {
+	var requests int64
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := atomic.AddInt64(&requests, 1)
+		if int(request) <= len(statuses) {
+			w.WriteHeader(statuses[request-1])
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	failures := make(chan error, 10)
+	sink := errors.NewWebhookSink(server.URL,
+		errors.WithWebhookRetries(2, time.Millisecond, 2*time.Millisecond),
+		errors.WithWebhookCircuitBreaker(2, time.Hour),
+	)
+	manager := errors.NewManager(
+		errors.WithDefaultLogger(errors.CustomLogger(errors.WithSink(sink))),
+		errors.WithSinkErrorHook(func(err errors.EnhancedError, logger errors.LogName, sinkErr error) {
+			failures <- sinkErr
+		}),
+	)
+	manager.RegisterFlusher(sink)
+	logAndFlush := func() {
+		errors.New("webhook").Bind(manager).Log()
+		assert.NoError(t, manager.Flush(context.Background()))
+	}
+
+	// retried until the collector accepts the batch
+	logAndFlush()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&requests))
+	assert.Empty(t, failures)
+
+	// client errors are not retried
+	logAndFlush()
+	assert.Equal(t, int64(4), atomic.LoadInt64(&requests))
+	assert.EqualError(t, <-failures, "writing error: collector responded with 400 Bad Request")
+
+	// second failed batch in a row opens the circuit
+	logAndFlush()
+	assert.Equal(t, int64(7), atomic.LoadInt64(&requests))
+	assert.EqualError(t, <-failures, "writing error: collector responded with 500 Internal Server Error")
+	logAndFlush()
+	assert.Equal(t, int64(7), atomic.LoadInt64(&requests))
+	assert.ErrorIs(t, <-failures, errors.ErrCircuitOpen)
+	assert.NoError(t, manager.Close(context.Background()))
+}
@@ -0,0 +1,340 @@
+package errors
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StackFrame is a single frame of the stack trace
+type StackFrame struct {
+	SourceFile   string `json:"file"`
+	SourceLine   string `json:"line"`
+	FunctionName string `json:"func"`
+}
+
+// StackEntry is a stack trace saved in the stack store under its hash. Stores which do not track metadata
+// leave the metadata fields empty.
+type StackEntry struct {
+	Hash   string       `json:"hash"`
+	Frames []StackFrame `json:"frames,omitempty"`
+	// Trace is the stack trace formatted when it was saved. Stores keeping only frames leave it empty.
+	Trace string `json:"-"`
+	// PCs are raw program counters saved with WithRawStacks, relative to the entry of the anchor function.
+	// They are resolved to frames with Symbolizer.
+	PCs []int64 `json:"pcs,omitempty"`
+	// BuildID is the Go build ID of the program which saved raw program counters
+	BuildID string `json:"buildID,omitempty"`
+
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Count is the number of occurrences of the stack trace
+	Count int64 `json:"count,omitempty"`
+	// Message is the message of the first error saved with the stack trace
+	Message string `json:"message,omitempty"`
+	// Template is the template ID of the first error saved with the stack trace
+	Template string `json:"template,omitempty"`
+	// Revision is the VCS revision of the program which saved the stack trace
+	Revision string `json:"revision,omitempty"`
+	// RevisionCounts is the number of occurrences per VCS revision. It is filled when entries are merged.
+	RevisionCounts map[string]int64 `json:"revisionCounts,omitempty"`
+
+	// resolve fills frames of the entry saved by the manager to a LazyStackStore
+	resolve func(*StackEntry)
+}
+
+// ResolveFrames fills frames and the formatted trace of the entry saved by the manager to a LazyStackStore,
+// unless they were already resolved. It does nothing for other entries.
+func (s *StackEntry) ResolveFrames() {
+	if s.resolve != nil {
+		resolve := s.resolve
+		s.resolve = nil
+		resolve(s)
+	}
+}
+
+// merge adds occurrences recorded in other entry of the same hash
+func (s *StackEntry) merge(other StackEntry) {
+	s.Count += occurrences(other)
+	for revision, count := range revisionCounts(other) {
+		if s.RevisionCounts == nil {
+			s.RevisionCounts = make(map[string]int64)
+		}
+		s.RevisionCounts[revision] += count
+	}
+	if !other.FirstSeen.IsZero() && (s.FirstSeen.IsZero() || other.FirstSeen.Before(s.FirstSeen)) {
+		s.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(s.LastSeen) {
+		s.LastSeen = other.LastSeen
+	}
+	if s.Frames == nil {
+		s.Frames = other.Frames
+	}
+	if s.Trace == "" {
+		s.Trace = other.Trace
+	}
+	if s.PCs == nil {
+		s.PCs = other.PCs
+		s.BuildID = other.BuildID
+	}
+	if s.Message == "" {
+		s.Message = other.Message
+	}
+	if s.Template == "" {
+		s.Template = other.Template
+	}
+	if s.Revision == "" {
+		s.Revision = other.Revision
+	}
+}
+
+// occurrences returns the number of occurrences recorded by the entry. Entries without count are single occurrences.
+func occurrences(entry StackEntry) int64 {
+	if entry.Count == 0 {
+		return 1
+	}
+	return entry.Count
+}
+
+// revisionCounts returns a copy of the occurrences per revision recorded by the entry. Entries which were not merged
+// record their occurrences under their revision.
+func revisionCounts(entry StackEntry) map[string]int64 {
+	if entry.RevisionCounts != nil {
+		counts := make(map[string]int64, len(entry.RevisionCounts))
+		for revision, count := range entry.RevisionCounts {
+			counts[revision] = count
+		}
+		return counts
+	}
+	if entry.Revision == "" {
+		return nil
+	}
+	return map[string]int64{entry.Revision: occurrences(entry)}
+}
+
+// Format returns the stack trace as it was saved, or frames formatted like MultilineStackTraceFormatter.
+// Raw program counters which were not symbolized are formatted as offsets from the anchor function.
+func (s StackEntry) Format() string {
+	if s.Trace != "" {
+		return s.Trace
+	}
+	var sb strings.Builder
+	if len(s.Frames) == 0 && len(s.PCs) > 0 {
+		_, anchorName := pcAnchorFunc()
+		for _, pc := range s.PCs {
+			sb.WriteString(fmt.Sprintf("\t%s%+#x\n\tbuild %s\n", anchorName, pc, s.BuildID))
+		}
+		return sb.String()
+	}
+	for _, f := range s.Frames {
+		sb.WriteString(fmt.Sprintf("\t%s\n\t%s:%s\n", f.FunctionName, f.SourceFile, f.SourceLine))
+	}
+	return sb.String()
+}
+
+// StackStore keeps stack traces saved by the manager. Stores buffering data implement Flusher as well.
+type StackStore interface {
+	// Has reports whether the stack trace with the hash is stored
+	Has(hash string) (bool, error)
+	// Get returns the stack trace stored under the hash
+	Get(hash string) (StackEntry, bool, error)
+	// Put stores the stack trace unless a stack trace with the same hash is already stored. Stores tracking
+	// metadata update the count and the last seen time of already stored stack traces instead.
+	Put(entry StackEntry) error
+	// Each calls fn for every stored stack trace. It stops on the first error returned by fn
+	Each(fn func(StackEntry) error) error
+	// Close releases resources held by the store
+	Close() error
+}
+
+// LazyStackStore is implemented by stack stores calling StackEntry.ResolveFrames in Put only for stack traces new
+// to the store, so frames of already saved stack traces are not resolved again. The manager resolves frames of entries
+// saved to other stores before Put. Stores wrapping other store can forward the method to it.
+type LazyStackStore interface {
+	ResolvesFramesLazily() bool
+}
+
+type StackFormat string
+
+const (
+	// StackFormatText is the text file with ">>> hash" headers followed by formatted stack traces
+	StackFormatText StackFormat = "text"
+	// StackFormatJSONL is the file with a single JSON encoded stack entry per line. It tracks occurrences of stack traces
+	// and reads stack traces written in the text format, so existing text stack files can be migrated by switching the format.
+	StackFormatJSONL StackFormat = "jsonl"
+	// StackFormatDir is the directory with a subdirectory for every stack hash
+	StackFormatDir StackFormat = "dir"
+)
+
+// OpenStackStore opens the stack store of the given format located at path. Options are the same as for Setup.
+func OpenStackStore(path string, format StackFormat, opts ...SetupOption) (StackStore, error) {
+	switch format {
+	case StackFormatText:
+		return NewTextStackStore(path, opts...)
+	case StackFormatJSONL:
+		return NewJSONLStackStore(path, opts...)
+	case StackFormatDir:
+		return NewDirStackStore(path)
+	}
+	return nil, fmt.Errorf("unknown stack format %s", format)
+}
+
+var buildRevision = struct {
+	once     sync.Once
+	revision string
+}{}
+
+// revision returns the VCS revision the program was built from, or empty string when it is not known
+func revision() string {
+	buildRevision.once.Do(func() {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				buildRevision.revision = setting.Value
+			}
+		}
+	})
+	return buildRevision.revision
+}
+
+// DetectStackFormat detects the format of the existing stack store located at path. Files with any JSON line
+// are detected as JSON Lines, as the format reads text stack traces migrated from the text format too.
+func DetectStackFormat(path string) (StackFormat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return StackFormatDir, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "{") {
+			return StackFormatJSONL, nil
+		}
+	}
+	return StackFormatText, scanner.Err()
+}
+
+// stackFrames returns frames of the stack trace with full function names and file paths
+func stackFrames(st errors.StackTrace) []StackFrame {
+	frames := make([]StackFrame, 0, len(st))
+	for _, f := range st {
+		pc := uintptr(f) - 1
+		fn := runtime.FuncForPC(pc)
+		if fn == nil {
+			frames = append(frames, StackFrame{SourceFile: "unknown", SourceLine: "0", FunctionName: "unknown"})
+			continue
+		}
+		file, line := fn.FileLine(pc)
+		frames = append(frames, StackFrame{
+			SourceFile:   file,
+			SourceLine:   fmt.Sprintf("%d", line),
+			FunctionName: fn.Name(),
+		})
+	}
+	return frames
+}
+
+// parseFrames parses stack trace formatted with MultilineStackTraceFormatter. It returns nil for other formats.
+func parseFrames(trace string) []StackFrame {
+	lines := strings.Split(strings.TrimRight(trace, "\n"), "\n")
+	if len(lines)%2 != 0 {
+		return nil
+	}
+	frames := make([]StackFrame, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		fileLine := strings.TrimPrefix(lines[i+1], "\t")
+		sep := strings.LastIndex(fileLine, ":")
+		if !strings.HasPrefix(lines[i], "\t") || sep < 0 {
+			return nil
+		}
+		frames = append(frames, StackFrame{
+			SourceFile:   fileLine[:sep],
+			SourceLine:   fileLine[sep+1:],
+			FunctionName: strings.TrimPrefix(lines[i], "\t"),
+		})
+	}
+	return frames
+}
+
+type memoryStackStore struct {
+	mu      sync.RWMutex
+	entries map[string]StackEntry
+	order   []string
+}
+
+// NewMemoryStackStore returns the stack store keeping stack traces in memory. It is meant for tests.
+func NewMemoryStackStore() StackStore {
+	return &memoryStackStore{entries: make(map[string]StackEntry)}
+}
+
+func (s *memoryStackStore) Has(hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.entries[hash]
+	return ok, nil
+}
+
+func (s *memoryStackStore) Get(hash string) (StackEntry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[hash]
+	return entry, ok, nil
+}
+
+func (s *memoryStackStore) ResolvesFramesLazily() bool {
+	return true
+}
+
+func (s *memoryStackStore) Put(entry StackEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.entries[entry.Hash]; ok {
+		existing.merge(entry)
+		s.entries[entry.Hash] = existing
+		return nil
+	}
+	entry.ResolveFrames()
+	entry.Count = occurrences(entry)
+	entry.RevisionCounts = revisionCounts(entry)
+	s.entries[entry.Hash] = entry
+	s.order = append(s.order, entry.Hash)
+	return nil
+}
+
+func (s *memoryStackStore) Each(fn func(StackEntry) error) error {
+	s.mu.RLock()
+	entries := make([]StackEntry, 0, len(s.order))
+	for _, hash := range s.order {
+		entries = append(entries, s.entries[hash])
+	}
+	s.mu.RUnlock()
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStackStore) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CompactStackFile rewrites all generations of the stack file into a single file. Stack traces saved in several
+// generations are merged and stack traces not seen within the window are dropped. Zero window keeps all stack traces.
+// Stack traces without the last seen time, like the ones in the text format, are treated as last seen when their
+// generation was modified. For the directory store only stack traces not seen within the window are removed.
+func CompactStackFile(path string, format StackFormat, window time.Duration) error {
+	var cutoff time.Time
+	if window > 0 {
+		cutoff = time.Now().Add(-window)
+	}
+	switch format {
+	case StackFormatText:
+		return compactStackFile(path, cutoff, readTextStacks, func(w io.Writer, entry StackEntry) error {
+			_, err := fmt.Fprintf(w, ">>> %s\n%s\n", entry.Hash, entry.Format())
+			return err
+		})
+	case StackFormatJSONL:
+		return compactStackFile(path, cutoff, readJSONLStacks, func(w io.Writer, entry StackEntry) error {
+			return json.NewEncoder(w).Encode(entry)
+		})
+	case StackFormatDir:
+		return compactStackDir(path, cutoff)
+	}
+	return fmt.Errorf("unknown stack format %s", format)
+}
+
+func compactStackFile(
+	path string,
+	cutoff time.Time,
+	read func(r io.Reader, fn func(StackEntry) error) error,
+	write func(w io.Writer, entry StackEntry) error,
+) error {
+	// hold the lock of the current file, so processes sharing the file wait for the compaction
+	current, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+	if err := lockFile(current); err != nil {
+		return err
+	}
+	defer unlockFile(current)
+
+	merger := newStackMerger()
+	err = readGenerations(path, func(r io.Reader, modTime time.Time) error {
+		return read(r, func(entry StackEntry) error {
+			if entry.LastSeen.IsZero() {
+				entry.LastSeen = modTime
+			}
+			return merger.add(entry)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if created, ok := readCreatedMarker(current); ok {
+		buffer.Write(createdMarker(created))
+	}
+	err = merger.each(func(entry StackEntry) error {
+		if entry.LastSeen.Before(cutoff) {
+			return nil
+		}
+		return write(&buffer, entry)
+	})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".compact.*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buffer.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	for _, generation := range rotatedGenerations(path) {
+		if err := os.Remove(generation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compactStackDir(root string, cutoff time.Time) error {
+	store, err := NewDirStackStore(root)
+	if err != nil {
+		return err
+	}
+	return store.Each(func(entry StackEntry) error {
+		lastSeen := entry.LastSeen
+		if lastSeen.IsZero() {
+			info, err := os.Stat(filepath.Join(root, entry.Hash, dirStackFile))
+			if err != nil {
+				return err
+			}
+			lastSeen = info.ModTime()
+		}
+		if lastSeen.Before(cutoff) {
+			return os.RemoveAll(filepath.Join(root, entry.Hash))
+		}
+		return nil
+	})
+}
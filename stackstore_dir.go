@@ -0,0 +1,140 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const dirStackFile = "stack.json"
+
+// dirStackStore keeps every stack trace in its own directory named by the stack hash
+type dirStackStore struct {
+	root string
+}
+
+// NewDirStackStore opens the stack directory, creating it when it does not exist. Stack hashes must be lowercase hex,
+// like the hashes of the built-in fingerprinters.
+func NewDirStackStore(root string) (StackStore, error) {
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, errors.Wrap(err, "creating error stack directory")
+	}
+	return &dirStackStore{root: root}, nil
+}
+
+// entryPath returns path of the stack file of the hash. Hashes are hex encoded fingerprints, other hashes are
+// rejected, so a hash given on the command line can not point outside the store.
+func (s *dirStackStore) entryPath(hash string) (string, error) {
+	if hash == "" || strings.TrimLeft(hash, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid stack hash %q", hash)
+	}
+	return filepath.Join(s.root, hash, dirStackFile), nil
+}
+
+func (s *dirStackStore) Has(hash string) (bool, error) {
+	path, err := s.entryPath(hash)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *dirStackStore) Get(hash string) (StackEntry, bool, error) {
+	path, err := s.entryPath(hash)
+	if err != nil {
+		return StackEntry{}, false, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return StackEntry{}, false, nil
+	}
+	if err != nil {
+		return StackEntry{}, false, err
+	}
+	var entry StackEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return StackEntry{}, false, errors.Wrap(err, "decoding stack entry")
+	}
+	return entry, true, nil
+}
+
+func (s *dirStackStore) ResolvesFramesLazily() bool {
+	return true
+}
+
+func (s *dirStackStore) Put(entry StackEntry) error {
+	path, err := s.entryPath(entry.Hash)
+	if err != nil {
+		return err
+	}
+	if ok, err := s.Has(entry.Hash); ok || err != nil {
+		return err
+	}
+	entry.ResolveFrames()
+	if entry.Frames == nil {
+		entry.Frames = parseFrames(entry.Trace)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	// write to temporary file first so readers never see partially written entry
+	tmp, err := os.CreateTemp(dir, dirStackFile+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *dirStackStore) Each(fn func(StackEntry) error) error {
+	dirEntries, err := os.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if _, err := s.entryPath(dirEntry.Name()); err == nil && dirEntry.IsDir() {
+			hashes = append(hashes, dirEntry.Name())
+		}
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		entry, ok, err := s.Get(hash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dirStackStore) Close() error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package errors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jsonlFlushInterval is the time after which occurrences of already stored stack traces are written to the file
+const jsonlFlushInterval = time.Minute
+
+// jsonlStackStore keeps stack traces in a file with a single JSON encoded stack entry per line. The first line of
+// a stack hash carries frames and metadata. Further occurrences are buffered and appended as short update lines
+// holding the hash, the number of new occurrences and the last seen time.
+type jsonlStackStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *sharedFile
+	stacks    map[string]bool
+	pending   map[string]StackEntry
+	lastFlush time.Time
+}
+
+// NewJSONLStackStore opens the JSON Lines stack file, reading hashes of the already saved stack traces.
+// The file may also contain stack traces written in the text format. The file can be shared between processes.
+// Rotation options passed to Setup are supported.
+func NewJSONLStackStore(path string, opts ...SetupOption) (StackStore, error) {
+	s := &jsonlStackStore{
+		path:      path,
+		stacks:    make(map[string]bool),
+		pending:   make(map[string]StackEntry),
+		lastFlush: time.Now(),
+	}
+	file, err := openSharedFile(path, newSetupOpts(opts).rotation, func(r io.Reader) error {
+		return readJSONLStacks(r, func(entry StackEntry) error {
+			s.stacks[entry.Hash] = true
+			return nil
+		})
+	}, func() {
+		s.stacks = make(map[string]bool)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading error stack file")
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *jsonlStackStore) Has(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.refresh(); err != nil {
+		return false, err
+	}
+	if s.stacks[hash] {
+		return true, nil
+	}
+	_, ok, err := s.Get(hash)
+	return ok, err
+}
+
+func (s *jsonlStackStore) Get(hash string) (StackEntry, bool, error) {
+	return findStackEntry(s, hash)
+}
+
+func (s *jsonlStackStore) ResolvesFramesLazily() bool {
+	return true
+}
+
+func (s *jsonlStackStore) Put(entry StackEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stacks[entry.Hash] {
+		update := entry
+		update.Count = 0
+		if pending, ok := s.pending[entry.Hash]; ok {
+			update = pending
+			update.Revision = entry.Revision
+		}
+		update.Count += occurrences(entry)
+		if entry.LastSeen.After(update.LastSeen) {
+			update.LastSeen = entry.LastSeen
+		}
+		s.pending[entry.Hash] = update
+		if time.Since(s.lastFlush) >= jsonlFlushInterval {
+			return s.flush()
+		}
+		return nil
+	}
+	entry.Count = occurrences(entry)
+	err := s.file.append(func() ([]byte, error) {
+		return s.encodeEntries(entry)
+	})
+	if err != nil {
+		return err
+	}
+	s.stacks[entry.Hash] = true
+	return nil
+}
+
+// flush writes buffered occurrences of already stored stack traces
+func (s *jsonlStackStore) flush() error {
+	s.lastFlush = time.Now()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	updates := make([]StackEntry, 0, len(s.pending))
+	for _, update := range s.pending {
+		updates = append(updates, update)
+	}
+	err := s.file.append(func() ([]byte, error) {
+		return s.encodeEntries(updates...)
+	})
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		s.stacks[update.Hash] = true
+	}
+	s.pending = make(map[string]StackEntry)
+	return nil
+}
+
+// Flush writes buffered occurrences and syncs the file to disk
+func (s *jsonlStackStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.file.sync()
+}
+
+// encodeEntries encodes entries as JSON lines, so they can be appended with a single write call. Entries already
+// saved in the current generation of the file, possibly by other process, are encoded as short update lines.
+func (s *jsonlStackStore) encodeEntries(entries ...StackEntry) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, entry := range entries {
+		if s.stacks[entry.Hash] {
+			entry = StackEntry{Hash: entry.Hash, LastSeen: entry.LastSeen, Count: entry.Count, Revision: entry.Revision}
+		} else {
+			entry.ResolveFrames()
+			if entry.Frames == nil {
+				entry.Frames = parseFrames(entry.Trace)
+			}
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// Each iterates over stack traces of all generations of the file. Occurrences saved in several generations are merged.
+func (s *jsonlStackStore) Each(fn func(StackEntry) error) error {
+	merger := newStackMerger()
+	err := readGenerations(s.path, func(r io.Reader, modTime time.Time) error {
+		return readJSONLStacks(r, merger.add)
+	})
+	if err != nil {
+		return err
+	}
+	return merger.each(fn)
+}
+
+func (s *jsonlStackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// stackMerger merges entries of the same hash into a single entry, keeping the order of their first appearance
+type stackMerger struct {
+	entries map[string]*StackEntry
+	order   []string
+}
+
+func newStackMerger() *stackMerger {
+	return &stackMerger{entries: make(map[string]*StackEntry)}
+}
+
+func (m *stackMerger) add(entry StackEntry) error {
+	if existing, ok := m.entries[entry.Hash]; ok {
+		existing.merge(entry)
+		return nil
+	}
+	entry.Count = occurrences(entry)
+	entry.RevisionCounts = revisionCounts(entry)
+	m.entries[entry.Hash] = &entry
+	m.order = append(m.order, entry.Hash)
+	return nil
+}
+
+func (m *stackMerger) each(fn func(StackEntry) error) error {
+	for _, hash := range m.order {
+		if err := fn(*m.entries[hash]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readJSONLStacks reads every line written in the JSON Lines format. Stack traces written in the text format are
+// read as well, so text stack files can be migrated to the JSON Lines format.
+func readJSONLStacks(r io.Reader, fn func(StackEntry) error) error {
+	var legacy strings.Builder
+	emitLegacy := func() error {
+		if legacy.Len() == 0 {
+			return nil
+		}
+		defer legacy.Reset()
+		return readTextStacks(strings.NewReader(legacy.String()), fn)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '{' {
+			legacy.Write(line)
+			legacy.WriteString("\n")
+			continue
+		}
+		if err := emitLegacy(); err != nil {
+			return err
+		}
+		var entry StackEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return errors.Wrap(err, "decoding stack entry")
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return emitLegacy()
+}
@@ -0,0 +1,58 @@
+package errors
+
+import (
+	"fmt"
+	"os"
+)
+
+// readOnlyStackStore reads the existing stack store without opening it for writing. Stack files are neither
+// created nor locked, so tools can inspect the stack files of running processes, also without write permission.
+type readOnlyStackStore struct {
+	path string
+	each func(fn func(StackEntry) error) error
+}
+
+// OpenStackStoreReadOnly opens the existing stack store for reading. Rotated generations of the stack file are read
+// as well. Put fails, as the store is meant for tools inspecting stack traces saved by other processes.
+func OpenStackStoreReadOnly(path string, format StackFormat) (StackStore, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &readOnlyStackStore{path: path}
+	switch format {
+	case StackFormatText:
+		s.each = (&textStackStore{path: path}).Each
+	case StackFormatJSONL:
+		s.each = (&jsonlStackStore{path: path}).Each
+	case StackFormatDir:
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", path)
+		}
+		s.each = (&dirStackStore{root: path}).Each
+	default:
+		return nil, fmt.Errorf("unknown stack format %s", format)
+	}
+	return s, nil
+}
+
+func (s *readOnlyStackStore) Has(hash string) (bool, error) {
+	_, ok, err := s.Get(hash)
+	return ok, err
+}
+
+func (s *readOnlyStackStore) Get(hash string) (StackEntry, bool, error) {
+	return findStackEntry(s, hash)
+}
+
+func (s *readOnlyStackStore) Put(entry StackEntry) error {
+	return fmt.Errorf("stack store %s is opened read-only", s.path)
+}
+
+func (s *readOnlyStackStore) Each(fn func(StackEntry) error) error {
+	return s.each(fn)
+}
+
+func (s *readOnlyStackStore) Close() error {
+	return nil
+}
@@ -0,0 +1,482 @@
+package errors_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enhanced-tools/errors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackStores(t *testing.T) {
+	stores := map[errors.StackFormat]string{
+		errors.StackFormatText:  "stacks.txt",
+		errors.StackFormatJSONL: "stacks.jsonl",
+		errors.StackFormatDir:   "stacks",
+	}
+	for format, name := range stores {
+		t.Run(string(format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			store, err := errors.OpenStackStore(path, format)
+			assert.NoError(t, err)
+
+			err1 := errors.New("first")
+			err2 := errors.New("second")
+			for _, e := range []errors.EnhancedError{err1, err2, err1} {
+				assert.NoError(t, store.Put(errors.StackEntry{
+					Hash:  e.GetStackTraceHash(),
+					Trace: errors.MultilineStackTraceFormatter(e.GetStackTrace()),
+				}))
+			}
+			assert.NoError(t, store.Close())
+
+			store, err = errors.OpenStackStore(path, format)
+			assert.NoError(t, err)
+			defer store.Close()
+			ok, err := store.Has(err1.GetStackTraceHash())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			ok, _ = store.Has("missing")
+			assert.False(t, ok)
+
+			entry, ok, err := store.Get(err2.GetStackTraceHash())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, errors.MultilineStackTraceFormatter(err2.GetStackTrace()), entry.Format())
+			assert.Equal(t, "github.com/enhanced-tools/errors.New", entry.Frames[0].FunctionName)
+
+			hashes := []string{}
+			assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+				hashes = append(hashes, entry.Hash)
+				return nil
+			}))
+			assert.ElementsMatch(t, []string{err1.GetStackTraceHash(), err2.GetStackTraceHash()}, hashes)
+		})
+	}
+}
+
+func TestSaveStackFormatsNewStacksOnly(t *testing.T) {
+	formats := map[errors.StackFormat]string{
+		errors.StackFormatText:  "stacks.txt",
+		errors.StackFormatJSONL: "stacks.jsonl",
+		errors.StackFormatDir:   "stacks",
+	}
+	for format, name := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			manager := errors.NewManager()
+			assert.NoError(t, manager.Setup(filepath.Join(t.TempDir(), name), errors.WithStackFormat(format)))
+			defer manager.Close(context.Background())
+			formatted := 0
+			formatter := func(st pkgerrors.StackTrace) string {
+				formatted++
+				return errors.MultilineStackTraceFormatter(st)
+			}
+			err := errors.New("saved twice").Bind(manager)
+			assert.NoError(t, manager.SaveStack(err, formatter))
+			assert.NoError(t, manager.SaveStack(err, formatter))
+			assert.Equal(t, 1, formatted)
+			entry, ok, getErr := manager.StackStore().Get(err.GetStackTraceHash())
+			assert.NoError(t, getErr)
+			assert.True(t, ok)
+			assert.Equal(t, errors.MultilineStackTraceFormatter(err.GetStackTrace()), entry.Format())
+		})
+	}
+}
+
+// wrappedStore wraps the stack store without forwarding ResolvesFramesLazily
+type wrappedStore struct {
+	errors.StackStore
+}
+
+// forwardingStore wraps the stack store, forwarding ResolvesFramesLazily to it
+type forwardingStore struct {
+	errors.StackStore
+}
+
+func (s forwardingStore) ResolvesFramesLazily() bool {
+	lazy, ok := s.StackStore.(errors.LazyStackStore)
+	return ok && lazy.ResolvesFramesLazily()
+}
+
+func TestSaveStackToWrappedStore(t *testing.T) {
+	for _, tc := range []struct {
+		store     errors.StackStore
+		formatted int
+	}{
+		{wrappedStore{errors.NewMemoryStackStore()}, 2},
+		{forwardingStore{errors.NewMemoryStackStore()}, 1},
+	} {
+		manager := errors.NewManager()
+		assert.NoError(t, manager.SetupStore(tc.store))
+		formatted := 0
+		formatter := func(st pkgerrors.StackTrace) string {
+			formatted++
+			return errors.MultilineStackTraceFormatter(st)
+		}
+		err := errors.New("saved twice").Bind(manager)
+		assert.NoError(t, manager.SaveStack(err, formatter))
+		assert.NoError(t, manager.SaveStack(err, formatter))
+		assert.Equal(t, tc.formatted, formatted)
+		entry, ok, getErr := tc.store.Get(err.GetStackTraceHash())
+		assert.NoError(t, getErr)
+		assert.True(t, ok)
+		assert.Equal(t, errors.MultilineStackTraceFormatter(err.GetStackTrace()), entry.Format())
+	}
+}
+
+func TestMemoryStackStore(t *testing.T) {
+	store := errors.NewMemoryStackStore()
+	err := errors.New("memory")
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: err.GetStackTraceHash()}))
+	ok, _ := store.Has(err.GetStackTraceHash())
+	assert.True(t, ok)
+}
+
+func TestJSONLStackStoreTracksOccurrences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks")
+	legacy, err := errors.NewTextStackStore(path)
+	assert.NoError(t, err)
+	legacyErr := errors.New("legacy")
+	assert.NoError(t, legacy.Put(errors.StackEntry{
+		Hash:  legacyErr.GetStackTraceHash(),
+		Trace: errors.MultilineStackTraceFormatter(legacyErr.GetStackTrace()),
+	}))
+	assert.NoError(t, legacy.Close())
+
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL)
+	assert.NoError(t, err)
+	tmpl := errors.Template()
+	var last errors.EnhancedError
+	for i := 0; i < 3; i++ {
+		last = tmpl.From(fmt.Errorf("occurrence"))
+		now := time.Now()
+		assert.NoError(t, store.Put(errors.StackEntry{
+			Hash:      last.GetStackTraceHash(),
+			FirstSeen: now,
+			LastSeen:  now,
+			Message:   last.Error(),
+			Template:  last.GetTemplateID(),
+		}))
+	}
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: legacyErr.GetStackTraceHash()}))
+	assert.NoError(t, store.Close())
+
+	store, err = errors.NewJSONLStackStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	entry, ok, err := store.Get(last.GetStackTraceHash())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), entry.Count)
+	assert.Equal(t, "occurrence", entry.Message)
+	assert.Equal(t, tmpl.GetTemplateID(), entry.Template)
+	assert.False(t, entry.FirstSeen.IsZero())
+	assert.False(t, entry.LastSeen.Before(entry.FirstSeen))
+
+	entry, ok, err = store.Get(legacyErr.GetStackTraceHash())
+	assert.NoError(t, err)
+	assert.True(t, ok, "legacy text entries should be read from JSON Lines file")
+	assert.Equal(t, int64(2), entry.Count)
+	assert.Equal(t, errors.MultilineStackTraceFormatter(legacyErr.GetStackTrace()), entry.Format())
+}
+
+// TestStackFileSharedBetweenProcesses runs several processes saving the same stack traces into one stack file.
+// Every stack trace must be written only once.
+func TestStackFileSharedBetweenProcesses(t *testing.T) {
+	const hashes = 20
+	if path := os.Getenv("TEST_STACK_WRITER_PATH"); path != "" {
+		store, err := errors.OpenStackStore(path, errors.StackFormat(os.Getenv("TEST_STACK_WRITER_FORMAT")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < hashes; i++ {
+			if err := store.Put(errors.StackEntry{Hash: fmt.Sprintf("hash-%d", i), Message: "writer", Trace: "\tmain.main\n\tmain.go:1\n"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("stack files can not be shared between processes on windows")
+	}
+	for _, format := range []errors.StackFormat{errors.StackFormatText, errors.StackFormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			const writers = 4
+			path := filepath.Join(t.TempDir(), "stacks")
+			cmds := make([]*exec.Cmd, 0, writers)
+			for i := 0; i < writers; i++ {
+				cmd := exec.Command(os.Args[0], "-test.run=^TestStackFileSharedBetweenProcesses$")
+				cmd.Env = append(os.Environ(), "TEST_STACK_WRITER_PATH="+path, "TEST_STACK_WRITER_FORMAT="+string(format))
+				assert.NoError(t, cmd.Start())
+				cmds = append(cmds, cmd)
+			}
+			for _, cmd := range cmds {
+				assert.NoError(t, cmd.Wait())
+			}
+
+			content, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			for i := 0; i < hashes; i++ {
+				hash := fmt.Sprintf("hash-%d", i)
+				if format == errors.StackFormatText {
+					assert.Equal(t, 1, strings.Count(string(content), fmt.Sprintf(">>> %s\n", hash)), "stack %s saved more than once", hash)
+				} else {
+					assert.Equal(t, 1, strings.Count(string(content), fmt.Sprintf(`"hash":%q,"frames"`, hash)), "stack %s saved more than once", hash)
+				}
+			}
+			store, err := errors.OpenStackStore(path, format)
+			assert.NoError(t, err)
+			defer store.Close()
+			entries := 0
+			assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+				entries++
+				if format == errors.StackFormatJSONL {
+					assert.Equal(t, int64(writers), entry.Count)
+				}
+				return nil
+			}))
+			assert.Equal(t, hashes, entries)
+		})
+	}
+}
+
+func TestStackFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks.jsonl")
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL, errors.WithStackRotation(1, 0), errors.WithStackRetention(2))
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, store.Put(errors.StackEntry{Hash: fmt.Sprintf("hash-%d", i)}))
+	}
+	// occurrence of the hash from rotated generation is saved in a new generation again
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: "hash-3"}))
+	defer store.Close()
+
+	for _, generation := range []string{path, path + ".1", path + ".2"} {
+		assert.FileExists(t, generation)
+	}
+	assert.NoFileExists(t, path+".3")
+	ok, err := store.Has("hash-3")
+	assert.NoError(t, err)
+	assert.True(t, ok, "stack from rotated generation should be found")
+	ok, _ = store.Has("hash-2")
+	assert.False(t, ok, "stack from removed generation should not be found")
+
+	counts := map[string]int64{}
+	assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+		counts[entry.Hash] = entry.Count
+		return nil
+	}))
+	assert.Equal(t, map[string]int64{"hash-3": 2, "hash-4": 1}, counts)
+}
+
+// TestStackFileRotationByFileAge rotates the file started before the store was opened, as after a restart
+func TestStackFileRotationByFileAge(t *testing.T) {
+	for _, format := range []errors.StackFormat{errors.StackFormatText, errors.StackFormatJSONL} {
+		path := filepath.Join(t.TempDir(), "stacks")
+		store, err := errors.OpenStackStore(path, format, errors.WithStackRotation(0, time.Hour))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Put(errors.StackEntry{Hash: "old"}))
+		assert.NoError(t, store.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		lines := strings.SplitN(string(content), "\n", 2)
+		assert.True(t, strings.HasPrefix(lines[0], "# created "), "file should start with the created marker")
+		started := "# created " + time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339Nano)
+		assert.NoError(t, os.WriteFile(path, []byte(started+"\n"+lines[1]), 0666))
+
+		store, err = errors.OpenStackStore(path, format, errors.WithStackRotation(0, time.Hour))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Put(errors.StackEntry{Hash: "new"}))
+		assert.FileExists(t, path+".1", format)
+		assert.NoError(t, store.Put(errors.StackEntry{Hash: "newer"}))
+		assert.NoFileExists(t, path+".2", "file started after the restart should not be rotated")
+
+		hashes := []string{}
+		assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+			hashes = append(hashes, entry.Hash)
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"old", "new", "newer"}, hashes, format)
+		assert.NoError(t, store.Close())
+	}
+}
+
+func TestOpenStackStoreReadOnly(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, err := errors.OpenStackStoreReadOnly(missing, errors.StackFormatJSONL)
+	assert.Error(t, err)
+	assert.NoFileExists(t, missing, "read-only store should not create the file")
+
+	for _, format := range []errors.StackFormat{errors.StackFormatText, errors.StackFormatJSONL, errors.StackFormatDir} {
+		path := filepath.Join(t.TempDir(), "stacks")
+		store, err := errors.OpenStackStore(path, format)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Put(errors.StackEntry{Hash: "5a7ed", Trace: "main.main()\n\tmain.go:1\n"}))
+		assert.NoError(t, store.Close())
+
+		readOnly, err := errors.OpenStackStoreReadOnly(path, format)
+		assert.NoError(t, err)
+		ok, err := readOnly.Has("5a7ed")
+		assert.NoError(t, err)
+		assert.True(t, ok, format)
+		entry, ok, err := readOnly.Get("5a7ed")
+		assert.NoError(t, err)
+		assert.True(t, ok, format)
+		assert.Equal(t, "5a7ed", entry.Hash)
+		assert.Error(t, readOnly.Put(errors.StackEntry{Hash: "ba5e"}), format)
+		assert.NoError(t, readOnly.Close())
+	}
+}
+
+func TestDirStackStoreRejectsInvalidHash(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	assert.NoError(t, os.MkdirAll(outside, 0777))
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "stack.json"), []byte(`{"hash":"outside"}`), 0666))
+
+	store, err := errors.NewDirStackStore(filepath.Join(root, "stacks"))
+	assert.NoError(t, err)
+	defer store.Close()
+	for _, hash := range []string{"../outside", "", "ABC", "a/b"} {
+		_, ok, err := store.Get(hash)
+		assert.Error(t, err, hash)
+		assert.False(t, ok, hash)
+		_, err = store.Has(hash)
+		assert.Error(t, err, hash)
+		assert.Error(t, store.Put(errors.StackEntry{Hash: hash}), hash)
+	}
+}
+
+func TestCompactStackFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks.jsonl")
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL, errors.WithStackRotation(1, 0))
+	assert.NoError(t, err)
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: "old", FirstSeen: old, LastSeen: old}))
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: "recent", FirstSeen: old, LastSeen: old}))
+	assert.NoError(t, store.Put(errors.StackEntry{Hash: "recent", LastSeen: time.Now()}))
+	assert.NoError(t, store.Close())
+
+	assert.NoError(t, errors.CompactStackFile(path, errors.StackFormatJSONL, 24*time.Hour))
+	assert.NoFileExists(t, path+".1")
+	store, err = errors.OpenStackStore(path, errors.StackFormatJSONL)
+	assert.NoError(t, err)
+	defer store.Close()
+	counts := map[string]int64{}
+	assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+		counts[entry.Hash] = entry.Count
+		return nil
+	}))
+	assert.Equal(t, map[string]int64{"recent": 2}, counts)
+}
+
+// TestSymbolizeRawStacks saves raw program counters in other process and resolves them against the test binary
+func TestSymbolizeRawStacks(t *testing.T) {
+	if path := os.Getenv("TEST_RAW_STACK_PATH"); path != "" {
+		if err := errors.Manager().Setup(path, errors.WithStackFormat(errors.StackFormatJSONL), errors.WithRawStacks()); err != nil {
+			t.Fatal(err)
+		}
+		err := errors.New("raw stack")
+		if err := errors.Manager().SaveStack(err); err != nil {
+			t.Fatal(err)
+		}
+		if err := errors.Manager().StackStore().Close(); err != nil {
+			t.Fatal(err)
+		}
+		expected := errors.MultilineStackTraceFormatter(err.GetStackTrace())
+		if err := os.WriteFile(path+".expected", []byte(expected), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		t.Skip("symbolization requires ELF binary")
+	}
+	path := filepath.Join(t.TempDir(), "stacks.jsonl")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSymbolizeRawStacks$")
+	cmd.Env = append(os.Environ(), "TEST_RAW_STACK_PATH="+path)
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL)
+	assert.NoError(t, err)
+	defer store.Close()
+	var entries []errors.StackEntry
+	assert.NoError(t, store.Each(func(entry errors.StackEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	assert.Len(t, entries, 1)
+	assert.NotEmpty(t, entries[0].PCs)
+	assert.Empty(t, entries[0].Frames)
+
+	symbolizer, err := errors.NewSymbolizer(os.Args[0])
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entries[0].BuildID)
+	assert.Equal(t, symbolizer.BuildID(), entries[0].BuildID)
+	symbolized, err := symbolizer.Symbolize(entries[0])
+	assert.NoError(t, err)
+	expected, err := os.ReadFile(path + ".expected")
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), symbolized.Format())
+
+	entries[0].BuildID = "other"
+	_, err = symbolizer.Symbolize(entries[0])
+	assert.Error(t, err)
+}
+
+func TestCompareRevisions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks.jsonl")
+	put := func(revision string, counts map[string]int64) {
+		store, err := errors.OpenStackStore(path, errors.StackFormatJSONL)
+		assert.NoError(t, err)
+		for hash, count := range counts {
+			for i := int64(0); i < count; i++ {
+				assert.NoError(t, store.Put(errors.StackEntry{Hash: hash, Revision: revision, Trace: "\tmain.main\n\tmain.go:1\n"}))
+			}
+		}
+		assert.NoError(t, store.Close())
+	}
+	put("v1", map[string]int64{"stable": 10, "fixed": 5, "rare": 1})
+	put("v2", map[string]int64{"stable": 10, "new": 2, "rare": 10})
+
+	store, err := errors.OpenStackStore(path, errors.StackFormatJSONL)
+	assert.NoError(t, err)
+	defer store.Close()
+	stable, _, err := store.Get("stable")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"v1": 10, "v2": 10}, stable.RevisionCounts)
+
+	comparison, err := errors.CompareRevisions(store, "v1", "v2", 2)
+	assert.NoError(t, err)
+	hashes := func(changes []errors.StackChange) []string {
+		var hashes []string
+		for _, change := range changes {
+			hashes = append(hashes, change.Hash)
+		}
+		return hashes
+	}
+	assert.Equal(t, []string{"new"}, hashes(comparison.Added))
+	assert.Equal(t, []string{"fixed"}, hashes(comparison.Removed))
+	assert.Equal(t, []string{"rare"}, hashes(comparison.Changed))
+	assert.Equal(t, int64(1), comparison.Changed[0].OldCount)
+	assert.Equal(t, int64(10), comparison.Changed[0].NewCount)
+
+	comparison, err = errors.CompareStacks(store, store, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, comparison.Added)
+	assert.Empty(t, comparison.Removed)
+	assert.Empty(t, comparison.Changed)
+}
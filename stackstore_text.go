@@ -0,0 +1,169 @@
+package errors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// textStackStore keeps stack traces in a text file. Every stack trace is preceded with the ">>> hash" line.
+// The file can be shared between processes.
+type textStackStore struct {
+	mu     sync.Mutex
+	path   string
+	file   *sharedFile
+	stacks map[string]bool
+}
+
+// NewTextStackStore opens the text stack file, reading hashes of the already saved stack traces.
+// Rotation options passed to Setup are supported.
+func NewTextStackStore(path string, opts ...SetupOption) (StackStore, error) {
+	s := &textStackStore{
+		path:   path,
+		stacks: make(map[string]bool),
+	}
+	file, err := openSharedFile(path, newSetupOpts(opts).rotation, func(r io.Reader) error {
+		return readTextStacks(r, func(entry StackEntry) error {
+			s.stacks[entry.Hash] = true
+			return nil
+		})
+	}, func() {
+		s.stacks = make(map[string]bool)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading error stack file")
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *textStackStore) Has(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.refresh(); err != nil {
+		return false, err
+	}
+	if s.stacks[hash] {
+		return true, nil
+	}
+	_, ok, err := s.Get(hash)
+	return ok, err
+}
+
+func (s *textStackStore) Get(hash string) (StackEntry, bool, error) {
+	return findStackEntry(s, hash)
+}
+
+func (s *textStackStore) ResolvesFramesLazily() bool {
+	return true
+}
+
+func (s *textStackStore) Put(entry StackEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stacks[entry.Hash] {
+		return nil
+	}
+	err := s.file.append(func() ([]byte, error) {
+		if s.stacks[entry.Hash] {
+			return nil, nil
+		}
+		entry.ResolveFrames()
+		trace := entry.Trace
+		if trace == "" {
+			trace = entry.Format()
+		}
+		return []byte(fmt.Sprintf(">>> %s\n%s\n", entry.Hash, trace)), nil
+	})
+	if err != nil {
+		return err
+	}
+	s.stacks[entry.Hash] = true
+	return nil
+}
+
+// Each iterates over stack traces of all generations of the file. Stack traces saved in several generations are
+// returned once, as saved in the oldest generation.
+func (s *textStackStore) Each(fn func(StackEntry) error) error {
+	seen := make(map[string]bool)
+	return readGenerations(s.path, func(r io.Reader, modTime time.Time) error {
+		return readTextStacks(r, func(entry StackEntry) error {
+			if seen[entry.Hash] {
+				return nil
+			}
+			seen[entry.Hash] = true
+			return fn(entry)
+		})
+	})
+}
+
+func (s *textStackStore) Close() error {
+	return s.file.Close()
+}
+
+// readTextStacks reads stack entries written in the text format
+func readTextStacks(r io.Reader, fn func(StackEntry) error) error {
+	var entry *StackEntry
+	var trace strings.Builder
+	emit := func() error {
+		if entry == nil {
+			return nil
+		}
+		entry.Trace = strings.TrimRight(trace.String(), "\n") + "\n"
+		entry.Frames = parseFrames(entry.Trace)
+		trace.Reset()
+		return fn(*entry)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.HasPrefix(text, ">>>") {
+			if err := emit(); err != nil {
+				return err
+			}
+			entry = &StackEntry{Hash: strings.TrimSpace(strings.TrimPrefix(text, ">>>"))}
+			continue
+		}
+		if entry != nil {
+			trace.WriteString(text)
+			trace.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return emit()
+}
+
+// errStopIteration stops iterating over the stack store
+var errStopIteration = fmt.Errorf("stop iteration")
+
+// Flush syncs the file to disk
+func (s *textStackStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.sync()
+}
+
+// findStackEntry looks up the entry by iterating over all stored stack traces
+func findStackEntry(s StackStore, hash string) (StackEntry, bool, error) {
+	var found StackEntry
+	err := s.Each(func(entry StackEntry) error {
+		if entry.Hash == hash {
+			found = entry
+			return errStopIteration
+		}
+		return nil
+	})
+	if err == errStopIteration {
+		return found, true, nil
+	}
+	return StackEntry{}, false, err
+}
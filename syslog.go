@@ -0,0 +1,236 @@
+package errors
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFacility is the syslog facility of the messages written by SyslogSink
+type SyslogFacility int
+
+const (
+	FacilityUser   SyslogFacility = 1
+	FacilityDaemon SyslogFacility = 3
+	FacilityAuth   SyslogFacility = 4
+	FacilityLocal0 SyslogFacility = 16
+	FacilityLocal1 SyslogFacility = 17
+	FacilityLocal2 SyslogFacility = 18
+	FacilityLocal3 SyslogFacility = 19
+	FacilityLocal4 SyslogFacility = 20
+	FacilityLocal5 SyslogFacility = 21
+	FacilityLocal6 SyslogFacility = 22
+	FacilityLocal7 SyslogFacility = 23
+)
+
+// syslogSDID is the structured data ID of error fields. 32473 is the enterprise number reserved for documentation.
+const syslogSDID = "error@32473"
+
+type syslogOpts struct {
+	facility  SyslogFacility
+	appName   string
+	hostname  string
+	verbosity int
+}
+
+type SyslogOption func(*syslogOpts)
+
+// WithSyslogFacility sets the facility of messages. The default is FacilityUser.
+func WithSyslogFacility(facility SyslogFacility) SyslogOption {
+	return func(o *syslogOpts) {
+		o.facility = facility
+	}
+}
+
+// WithSyslogAppName sets the APP-NAME of messages. The default is the name of the executable.
+func WithSyslogAppName(appName string) SyslogOption {
+	return func(o *syslogOpts) {
+		o.appName = appName
+	}
+}
+
+// WithSyslogHostname sets the HOSTNAME of messages. The default is the host name reported by the kernel.
+func WithSyslogHostname(hostname string) SyslogOption {
+	return func(o *syslogOpts) {
+		o.hostname = hostname
+	}
+}
+
+// WithSyslogVerbosity sets the verbosity threshold of options written as structured data
+func WithSyslogVerbosity(verbosity int) SyslogOption {
+	return func(o *syslogOpts) {
+		o.verbosity = verbosity
+	}
+}
+
+// SyslogSink writes errors as RFC 5424 syslog messages. The formatted record is the message, the error ID,
+// stack trace hash, template and options are written as the structured data element "error@32473".
+// The template ID is the MSGID of the message and the severity of the error is mapped to the syslog severity.
+type SyslogSink struct {
+	network string
+	address string
+	opts    *syslogOpts
+	pid     string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink connects to the syslog server. Network is "unix" or "unixgram" for the local socket, empty address
+// meaning /dev/log, or "udp" or "tcp". Messages sent over stream connections are framed with octet counting
+// (RFC 6587), datagrams hold a single message. Broken connections are redialed on the next write.
+func NewSyslogSink(network, address string, opts ...SyslogOption) (*SyslogSink, error) {
+	options := &syslogOpts{facility: FacilityUser}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.appName == "" {
+		if executable, err := os.Executable(); err == nil {
+			options.appName = executable[strings.LastIndexAny(executable, `/\`)+1:]
+		}
+	}
+	if options.hostname == "" {
+		options.hostname, _ = os.Hostname()
+	}
+	if address == "" && strings.HasPrefix(network, "unix") {
+		address = "/dev/log"
+	}
+	s := &SyslogSink{
+		network: network,
+		address: address,
+		opts:    options,
+		pid:     strconv.Itoa(os.Getpid()),
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+func (s *SyslogSink) WriteError(e EnhancedError, record []byte) error {
+	message := s.message(e, record, time.Now())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if err := s.write(message); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.Dial(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return s.write(message)
+}
+
+func (s *SyslogSink) write(message []byte) error {
+	if s.network == "tcp" || s.network == "unix" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	_, err := s.conn.Write(message)
+	return err
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// message formats the RFC 5424 message: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func (s *SyslogSink) message(e EnhancedError, record []byte, now time.Time) []byte {
+	severity := LogError
+	msgID := ""
+	if e != nil {
+		severity = SeverityOf(e)
+		msgID = e.GetTemplateID()
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s %s ",
+		int(s.opts.facility)*8+syslogSeverity(severity),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.opts.hostname, 255),
+		syslogHeaderField(s.opts.appName, 48),
+		s.pid,
+		syslogHeaderField(msgID, 32),
+	)
+	if fields := sinkFields(e, s.opts.verbosity); len(fields) > 0 {
+		buf.WriteString("[" + syslogSDID)
+		for _, field := range fields {
+			name := syslogParamName(field.key)
+			if name == "" {
+				continue
+			}
+			fmt.Fprintf(&buf, ` %s="%s"`, name, syslogParamEscaper.Replace(field.value))
+		}
+		buf.WriteString("]")
+	} else {
+		buf.WriteString("-")
+	}
+	if record = bytes.TrimRight(record, "\n"); len(record) > 0 {
+		buf.WriteString(" ")
+		buf.Write(record)
+	}
+	return buf.Bytes()
+}
+
+// syslogSeverity maps the severity of the error to the syslog severity
+func syslogSeverity(severity int) int {
+	switch {
+	case severity <= LogDebug:
+		return 7
+	case severity == LogInfo:
+		return 6
+	case severity == LogWarning:
+		return 4
+	}
+	return 3
+}
+
+// syslogHeaderField returns the value as the header field of at most size printable ASCII characters, "-" when empty
+func syslogHeaderField(value string, size int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(field) > size {
+		field = field[:size]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// syslogParamName returns the key as SD-PARAM name, dropping characters which are not allowed
+func syslogParamName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, key)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+var syslogParamEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
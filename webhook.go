@@ -0,0 +1,301 @@
+package errors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is reported when the batch is not sent because the collector failed too many times in a row
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open")
+
+// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the request body, prefixed with "sha256="
+const WebhookSignatureHeader = "X-Signature-256"
+
+type webhookOpts struct {
+	client          *http.Client
+	header          http.Header
+	secret          []byte
+	compress        bool
+	verbosity       int
+	retries         int
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+	breakerFailures int
+	breakerCooldown time.Duration
+	batchOpts       []BatchSinkOption
+}
+
+type WebhookOption func(*webhookOpts)
+
+// WithWebhookClient sets the HTTP client sending requests. The default client times out after 10 seconds.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(o *webhookOpts) {
+		o.client = client
+	}
+}
+
+// WithWebhookHeader adds the header to every request, for example the authorization token
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(o *webhookOpts) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithWebhookSecret signs request bodies with HMAC-SHA256 using the secret, see WebhookSignatureHeader
+func WithWebhookSecret(secret []byte) WebhookOption {
+	return func(o *webhookOpts) {
+		o.secret = secret
+	}
+}
+
+// WithWebhookCompression sets whether request bodies are compressed with gzip. They are not compressed by default.
+func WithWebhookCompression(compress bool) WebhookOption {
+	return func(o *webhookOpts) {
+		o.compress = compress
+	}
+}
+
+// WithWebhookVerbosity sets the verbosity threshold of options sent to the collector. The default is 100, like AsJSON.
+func WithWebhookVerbosity(verbosity int) WebhookOption {
+	return func(o *webhookOpts) {
+		o.verbosity = verbosity
+	}
+}
+
+// WithWebhookRetries sets how many times a failed batch is sent again. The delay starts at minBackoff and doubles
+// with every retry up to maxBackoff. The default is 3 retries with backoff from 100ms to 5s.
+func WithWebhookRetries(retries int, minBackoff, maxBackoff time.Duration) WebhookOption {
+	return func(o *webhookOpts) {
+		o.retries = retries
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithWebhookCircuitBreaker stops sending batches for cooldown after the given number of batches failed in a row.
+// Batches logged meanwhile fail with ErrCircuitOpen. The default is 5 failures and 30s cooldown.
+func WithWebhookCircuitBreaker(failures int, cooldown time.Duration) WebhookOption {
+	return func(o *webhookOpts) {
+		o.breakerFailures = failures
+		o.breakerCooldown = cooldown
+	}
+}
+
+// WithWebhookBatch sets the queue and batch options of the sink
+func WithWebhookBatch(opts ...BatchSinkOption) WebhookOption {
+	return func(o *webhookOpts) {
+		o.batchOpts = append(o.batchOpts, opts...)
+	}
+}
+
+// WebhookSink posts batches of errors to the collector as JSON array of errors formatted with AsJSON, with the stack
+// trace in the "stackTrace" field. Errors are queued and sent on a separate goroutine, like with BatchSink. Failed
+// batches are retried on network errors, 429 and 5xx responses and reported with ReportSinkError after the last retry.
+type WebhookSink struct {
+	*BatchSink
+	poster *webhookPoster
+}
+
+// NewWebhookSink returns the sink posting errors to url. The sink should be registered with RegisterFlusher,
+// so queued errors are sent by Flush and Close of the manager.
+func NewWebhookSink(url string, opts ...WebhookOption) *WebhookSink {
+	options := &webhookOpts{
+		client:          &http.Client{Timeout: 10 * time.Second},
+		header:          make(http.Header),
+		verbosity:       100,
+		retries:         3,
+		minBackoff:      100 * time.Millisecond,
+		maxBackoff:      5 * time.Second,
+		breakerFailures: 5,
+		breakerCooldown: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	poster := &webhookPoster{url: url, opts: options}
+	poster.ctx, poster.cancel = context.WithCancel(context.Background())
+	return &WebhookSink{BatchSink: NewBatchSink(poster, options.batchOpts...), poster: poster}
+}
+
+// Close sends queued errors, retrying failed batches, and stops the sink. Use Shutdown to bound the time it takes.
+func (s *WebhookSink) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown sends queued errors and stops the sink like Close. When ctx is done first, the request in flight and
+// the retry waiting for the backoff are canceled, and errors still queued fail without waiting for the collector.
+// Errors which were not sent are reported with ReportSinkError. Close of the manager calls it with its context.
+func (s *WebhookSink) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.poster.cancel()
+		case <-done:
+		}
+	}()
+	err := s.BatchSink.Close()
+	close(done)
+	s.poster.cancel()
+	return err
+}
+
+// webhookPoster sends batches to the collector, counting failed batches for the circuit breaker
+type webhookPoster struct {
+	url  string
+	opts *webhookOpts
+	// ctx is canceled when the sink is shut down
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// WriteError sends the single error, so the poster can be used without batching
+func (p *webhookPoster) WriteError(e EnhancedError, record []byte) error {
+	return p.WriteBatch([]EnhancedError{e}, [][]byte{record})
+}
+
+func (p *webhookPoster) WriteBatch(errs []EnhancedError, records [][]byte) error {
+	if !p.allow() {
+		return ErrCircuitOpen
+	}
+	body, err := p.body(errs)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := p.post(body)
+		if err == nil {
+			p.result(true)
+			return nil
+		}
+		if !retry || attempt >= p.opts.retries || !p.wait(p.backoff(attempt)) {
+			p.result(false)
+			return err
+		}
+	}
+}
+
+// wait waits for the delay and returns false when the sink was closed meanwhile
+func (p *webhookPoster) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// allow returns whether the batch can be sent. After the cooldown the circuit is half open, one failed batch opens it again.
+func (p *webhookPoster) allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opts.breakerFailures <= 0 || time.Now().After(p.openUntil)
+}
+
+func (p *webhookPoster) result(success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if success {
+		p.failures = 0
+		return
+	}
+	p.failures++
+	if p.opts.breakerFailures > 0 && p.failures >= p.opts.breakerFailures {
+		p.openUntil = time.Now().Add(p.opts.breakerCooldown)
+	}
+}
+
+// backoff returns the delay before the retry, doubled with every attempt and randomized by up to a half
+func (p *webhookPoster) backoff(attempt int) time.Duration {
+	delay := p.opts.minBackoff
+	for i := 0; i < attempt && delay < p.opts.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.opts.maxBackoff {
+		delay = p.opts.maxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// body returns the JSON array of errors, compressed when enabled
+func (p *webhookPoster) body(errs []EnhancedError) ([]byte, error) {
+	payload := make([]json.RawMessage, 0, len(errs))
+	for _, e := range errs {
+		fields := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(AsJSON(e, p.opts.verbosity), &fields); err != nil {
+			return nil, err
+		}
+		if stackTrace := JSONStackTraceFormatter(e.GetStackTrace()); json.Valid([]byte(stackTrace)) {
+			fields["stackTrace"] = json.RawMessage(stackTrace)
+		}
+		errorBytes, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, errorBytes)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil || !p.opts.compress {
+		return body, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body and returns whether the failed request can be retried
+func (p *webhookPoster) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range p.opts.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.opts.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if len(p.opts.secret) > 0 {
+		mac := hmac.New(sha256.New, p.opts.secret)
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.opts.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("collector responded with %s", resp.Status)
+}